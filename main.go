@@ -2,26 +2,47 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/moby/moby/client"
+	"github.com/sithukyaw666/watcher/metrics"
 	"github.com/sithukyaw666/watcher/model"
 	"github.com/sithukyaw666/watcher/operations"
+	"github.com/sithukyaw666/watcher/operations/controller"
+	"github.com/sithukyaw666/watcher/server"
 	"github.com/sithukyaw666/watcher/utils"
 )
 
 func main() {
-	// Create structured logger
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
-
 	healthCheck := flag.Bool("health-check", false, "Run a health check and exit.")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [plan|history [command flags]]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
+	// Commands reserve stdout for their own output, so logs go to stderr.
+	logOutput := os.Stdout
+	if flag.Arg(0) != "" {
+		logOutput = os.Stderr
+	}
+
+	// Create structured logger
+	logger := slog.New(slog.NewTextHandler(logOutput, nil))
+
 	if *healthCheck {
 		logger.Info("Performing health check...")
 
@@ -44,51 +65,196 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	logger.Info("WatcherCD starting...")
-
 	config, err := utils.LoadConfig()
 	if err != nil {
 		logger.Error("Failed to load configuration", "error", err)
 		os.Exit(1)
 	}
 
-	clientOpts := []client.Opt{client.FromEnv}
-	if config.DockerAPIVersion != "" {
-		logger.Info("Using specific Docker API version", "version", config.DockerAPIVersion)
-		clientOpts = append(clientOpts, client.WithVersion(config.DockerAPIVersion))
-	} else {
-		logger.Info("Docker API version not specified, using automatic negotiation.")
-		clientOpts = append(clientOpts, client.WithAPIVersionNegotiation())
+	projects, err := utils.ResolveProjects(config)
+	if err != nil {
+		logger.Error("Invalid project configuration", "error", err)
+		os.Exit(1)
+	}
+
+	switch flag.Arg(0) {
+	case "":
+	case "plan":
+		os.Exit(runPlan(ctx, config, projects, flag.Args()[1:], logger))
+	case "history":
+		os.Exit(runHistory(projects, flag.Args()[1:], logger))
+	default:
+		logger.Error("Unknown command", "command", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
 	}
-	cli, err := client.NewClientWithOpts(clientOpts...)
+
+	logger.Info("WatcherCD starting...", "projects", len(projects))
+
+	cli, err := newDockerClient(config, logger)
 	if err != nil {
 		logger.Error("Failed to create docker client", "error", err)
 		os.Exit(1)
 	}
 	defer cli.Close()
 
+	triggers := make(map[string]chan struct{}, len(projects))
+	for _, project := range projects {
+		// A buffer of one coalesces any number of triggers into a single pending cycle.
+		triggers[project.ProjectName] = make(chan struct{}, 1)
+	}
+
+	var wg sync.WaitGroup
+	if config.ListenAddr != "" {
+		handler, err := newHTTPHandler(config, projects, triggers, logger)
+		if err != nil {
+			logger.Error("Failed to configure HTTP server", "error", err)
+			os.Exit(1)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := server.Run(ctx, config.ListenAddr, handler, logger); err != nil {
+				logger.Error("HTTP server failed", "error", err)
+			}
+		}()
+	}
+
+	for _, project := range projects {
+		projectLogger := logger.With("project", project.ProjectName)
+		repairs := make(chan string)
+		runs := &operations.RunTracker{}
+		if project.SelfHeal != nil && *project.SelfHeal && !project.DryRun {
+			wg.Add(1)
+			go func(project model.Config) {
+				defer wg.Done()
+				operations.WatchEvents(ctx, cli, project, runs, repairs, projectLogger)
+			}(project)
+		}
+		wg.Add(1)
+		go func(project model.Config) {
+			defer wg.Done()
+			runProject(ctx, cli, project, triggers[project.ProjectName], repairs, runs, projectLogger)
+		}(project)
+	}
+	wg.Wait()
+	logger.Info("Shutdown signal received. Exiting gracefully.")
+}
+
+// newHTTPHandler builds the routes of the embedded HTTP server.
+func newHTTPHandler(config model.Config, projects []model.Config, triggers map[string]chan struct{}, logger *slog.Logger) (http.Handler, error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/history", server.NewHistoryHandler(projects, logger.With("component", "history")))
+
+	secret := config.WebhookSecret
+	if config.WebhookSecretFile != "" {
+		data, err := os.ReadFile(config.WebhookSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read webhook secret file: %w", err)
+		}
+		secret = strings.TrimSpace(string(data))
+	}
+	if secret == "" {
+		logger.Warn("No webhook secret configured, the push webhook endpoint is disabled.")
+	} else {
+		var targets []server.WebhookTarget
+		for _, project := range projects {
+			trigger := triggers[project.ProjectName]
+			targets = append(targets, server.WebhookTarget{
+				Project: project.ProjectName,
+				RepoURL: project.RepoURL,
+				Branch:  project.TargetBranch,
+				Trigger: func() {
+					select {
+					case trigger <- struct{}{}:
+					default: // A cycle is already pending.
+					}
+				},
+			})
+		}
+		mux.Handle("/webhook", server.NewWebhookHandler(secret, targets, logger.With("component", "webhook")))
+	}
+	return mux, nil
+}
+
+// runProject reconciles a single project on its own interval, or immediately when triggered,
+// until ctx is cancelled. Polling remains active as a fallback for missed webhooks. Services
+// received on repairs are reconciled on their own, never concurrently with a full cycle.
+// Every run is recorded in runs, so self-healing ignores the events Watcher causes itself.
+func runProject(ctx context.Context, cli *client.Client, config model.Config, trigger <-chan struct{}, repairs <-chan string, runs *operations.RunTracker, logger *slog.Logger) {
+	if config.DryRun {
+		logger.Info("Dry-run mode enabled, reconciliation will only report intended actions.")
+	}
+	track := func(run func()) {
+		runs.Begin()
+		defer runs.End()
+		run()
+	}
+
 	logger.Info("Performing initial reconciliation check...")
-	runCycle(ctx, cli, config, logger) // Pass logger
+	track(func() { runCycle(ctx, cli, config, logger) })
 
-	ticker := time.NewTicker(time.Duration(config.CheckInterval) * time.Second)
+	interval := time.Duration(config.CheckInterval) * time.Second
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	// Image update checks run on their own schedule; a nil channel disables them.
+	var imageChecks <-chan time.Time
+	if config.ImageCheckInterval > 0 && !config.DryRun {
+		imageTicker := time.NewTicker(time.Duration(config.ImageCheckInterval) * time.Second)
+		defer imageTicker.Stop()
+		imageChecks = imageTicker.C
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
-			logger.Info("Shutdown signal received. Exiting gracefully.")
 			return
 		case <-ticker.C:
 			logger.Info("Running periodic reconciliation check...")
-			runCycle(ctx, cli, config, logger) // Pass logger
+			track(func() { runCycle(ctx, cli, config, logger) })
+		case <-trigger:
+			logger.Info("Running triggered reconciliation check...")
+			track(func() { runCycle(ctx, cli, config, logger) })
+			ticker.Reset(interval)
+		case service := <-repairs:
+			logger.Info("Running self-healing reconciliation...", "service_name", service)
+			track(func() { runRepair(ctx, cli, config, "self-heal", []string{service}, logger) })
+		case <-imageChecks:
+			logger.Info("Checking registries for image updates...")
+			track(func() { runImageUpdate(ctx, cli, config, logger) })
 		}
 	}
 }
 
+func newDockerClient(config model.Config, logger *slog.Logger) (*client.Client, error) {
+	clientOpts := []client.Opt{client.FromEnv}
+	if config.DockerAPIVersion != "" {
+		logger.Info("Using specific Docker API version", "version", config.DockerAPIVersion)
+		clientOpts = append(clientOpts, client.WithVersion(config.DockerAPIVersion))
+	} else {
+		logger.Info("Docker API version not specified, using automatic negotiation.")
+		clientOpts = append(clientOpts, client.WithAPIVersionNegotiation())
+	}
+	return client.NewClientWithOpts(clientOpts...)
+}
+
 func runCycle(ctx context.Context, cli *client.Client, config model.Config, logger *slog.Logger) {
-	update, err := operations.CloneOrFetchRepo(config, logger) // Pass logger
+	entry := operations.HistoryEntry{Project: config.ProjectName, StartedAt: time.Now().UTC(), DryRun: config.DryRun, Actions: []controller.Action{}}
+	defer finishEntry(config, &entry, "reconciliation cycle", logger)
+
+	state, err := operations.LoadState(config)
+	if err != nil {
+		logger.Error("ERROR loading deployment state", "error", err)
+		entry.AddError(err)
+		return
+	}
+
+	update, err := operations.CloneOrFetchRepo(config, state, logger) // Pass logger
 	if err != nil {
 		logger.Error("ERROR during git operation", "error", err)
+		entry.AddError(err)
 		return
 	}
 	if update != nil {
@@ -97,7 +263,269 @@ func runCycle(ctx context.Context, cli *client.Client, config model.Config, logg
 		logger.Info("No repository changes detected. But ensuring services are reconciled.")
 	}
 
-	if err := operations.Deploy(ctx, cli, config, logger); err != nil { // Pass logger
-		logger.Error("ERROR during reconciliation", "error", err)
+	head, err := operations.HeadCommit(config)
+	if err != nil {
+		logger.Error("ERROR reading deployed commit", "error", err)
+		entry.AddError(err)
+		return
+	}
+	entry.OldCommit, entry.NewCommit = head.String(), head.String()
+	if update != nil && !update.WasCloned {
+		entry.OldCommit = update.OldHash.String()
+	}
+	if entry.Author, entry.Message, err = operations.CommitInfo(config, head); err != nil {
+		logger.Warn("Could not read commit details", "error", err)
+	}
+
+	plan, deployErr := operations.Deploy(ctx, cli, config, logger) // Pass logger
+	if plan != nil {
+		entry.Actions = append(entry.Actions, plan.Actions...)
+	}
+	if config.DryRun {
+		if deployErr != nil {
+			logger.Error("ERROR during reconciliation", "error", deployErr)
+			entry.AddError(deployErr)
+		} else {
+			plan.WriteText(os.Stdout)
+		}
+		return
+	}
+
+	if deployErr == nil {
+		changed := state.LastGoodHash != head.String()
+		state.LastGoodHash = head.String()
+		// Only changed containers leave images unused. The first run records the images in use,
+		// so they can be pruned once they are replaced.
+		if config.ImageRetention > 0 && (plan.ChangesContainers() || state.Images == nil) {
+			pruneImages(ctx, cli, config, state, &entry, logger)
+			changed = true
+		}
+		if changed {
+			if err := operations.SaveState(config, state); err != nil {
+				logger.Error("ERROR saving deployment state", "error", err)
+			}
+		}
+		return
+	}
+
+	logger.Error("ERROR during reconciliation", "error", deployErr)
+	entry.AddError(deployErr)
+	target, rollbackPlan, err := operations.Rollback(ctx, cli, config, state, head, update, logger)
+	if !target.IsZero() {
+		entry.RolledBackTo = target.String()
+	}
+	if rollbackPlan != nil {
+		entry.Actions = append(entry.Actions, rollbackPlan.Actions...)
+	}
+	if err != nil {
+		logger.Error("Rollback not performed", "error", err)
+		entry.AddError(fmt.Errorf("rollback: %w", err))
+	}
+}
+
+// runImageUpdate pulls images whose tag moved in the registry and re-creates the services using them.
+func runImageUpdate(ctx context.Context, cli *client.Client, config model.Config, logger *slog.Logger) {
+	entry := operations.HistoryEntry{Project: config.ProjectName, StartedAt: time.Now().UTC(), Trigger: "image-update", Actions: []controller.Action{}}
+	defer finishEntry(config, &entry, "image update", logger)
+
+	services, err := operations.PullUpdatedImages(ctx, cli, config, logger)
+	if err != nil {
+		logger.Error("ERROR checking for image updates", "error", err)
+		entry.AddError(err)
+	}
+	if len(services) == 0 {
+		return
+	}
+	logger.Info("Re-creating services with updated images", "services", services)
+	plan := repairServices(ctx, cli, config, services, &entry, logger)
+	if config.ImageRetention == 0 || plan == nil || !plan.ChangesContainers() {
+		return
+	}
+	state, err := operations.LoadState(config)
+	if err != nil {
+		logger.Error("ERROR loading deployment state", "error", err)
+		entry.AddError(err)
+		return
+	}
+	pruneImages(ctx, cli, config, state, &entry, logger)
+	if err := operations.SaveState(config, state); err != nil {
+		logger.Error("ERROR saving deployment state", "error", err)
+	}
+}
+
+// runRepair reconciles only the given services of the currently deployed commit. trigger is
+// recorded in the deployment history.
+func runRepair(ctx context.Context, cli *client.Client, config model.Config, trigger string, services []string, logger *slog.Logger) {
+	entry := operations.HistoryEntry{Project: config.ProjectName, StartedAt: time.Now().UTC(), Trigger: trigger, Actions: []controller.Action{}}
+	defer finishEntry(config, &entry, "targeted reconciliation", logger)
+
+	repairServices(ctx, cli, config, services, &entry, logger)
+}
+
+// repairServices reconciles the given services, records the outcome in entry and returns the
+// plan that was applied.
+func repairServices(ctx context.Context, cli *client.Client, config model.Config, services []string, entry *operations.HistoryEntry, logger *slog.Logger) *controller.Plan {
+	if head, err := operations.HeadCommit(config); err == nil {
+		entry.OldCommit, entry.NewCommit = head.String(), head.String()
+	}
+
+	plan, err := operations.RepairServices(ctx, cli, config, services, logger)
+	if plan != nil {
+		entry.Actions = append(entry.Actions, plan.Actions...)
+	}
+	if err != nil {
+		logger.Error("ERROR during targeted reconciliation", "services", services, "error", err)
+		entry.AddError(err)
+	}
+	return plan
+}
+
+// pruneImages removes images replaced by deployments and records the removals in entry.
+func pruneImages(ctx context.Context, cli *client.Client, config model.Config, state *model.DeploymentState, entry *operations.HistoryEntry, logger *slog.Logger) {
+	removed, err := operations.PruneImages(ctx, cli, config, state, logger)
+	entry.Actions = append(entry.Actions, removed...)
+	if err != nil {
+		logger.Warn("Could not prune unused images", "error", err)
+	}
+}
+
+// finishEntry is deferred by every run: it turns a panic into an error of the run, so a panic
+// in one project does not take down the others, and records the entry in the history.
+func finishEntry(config model.Config, entry *operations.HistoryEntry, run string, logger *slog.Logger) {
+	if r := recover(); r != nil {
+		logger.Error("PANIC during "+run, "panic", r)
+		entry.AddError(fmt.Errorf("panic: %v", r))
+	}
+	entry.DurationSeconds = time.Since(entry.StartedAt).Seconds()
+	if entry.Eventful() {
+		if err := operations.AppendHistory(config, *entry); err != nil {
+			logger.Error("ERROR recording deployment history", "error", err)
+		}
+	}
+}
+
+// runPlan implements the "plan" command: it computes the actions a deployment of the
+// currently checked out compose file would take and prints them without changing anything.
+func runPlan(ctx context.Context, globalConfig model.Config, projects []model.Config, args []string, logger *slog.Logger) int {
+	planFlags := flag.NewFlagSet("plan", flag.ContinueOnError)
+	format := planFlags.String("format", "text", "Output format: text or json.")
+	composeFile := planFlags.String("file", "", "Compose file to plan instead of the one in the deployment directory.")
+	projectName := planFlags.String("project", "", "Project to plan. Required when several projects are configured.")
+	if err := planFlags.Parse(args); err != nil {
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		logger.Error("Unsupported output format", "format", *format)
+		return 2
+	}
+
+	var config model.Config
+	switch {
+	case *projectName != "":
+		found := false
+		for _, p := range projects {
+			if p.ProjectName == *projectName {
+				config, found = p, true
+			}
+		}
+		if !found {
+			logger.Error("Unknown project", "project", *projectName)
+			return 2
+		}
+	case len(projects) == 1:
+		config = projects[0]
+	default:
+		logger.Error("Several projects are configured, select one with -project")
+		return 2
+	}
+
+	config.DryRun = true
+	if *composeFile != "" {
+		absPath, err := filepath.Abs(*composeFile)
+		if err != nil {
+			logger.Error("Invalid compose file path", "path", *composeFile, "error", err)
+			return 1
+		}
+		config.ComposeFile = absPath
+		config.ComposeFiles = nil
+	}
+
+	cli, err := newDockerClient(globalConfig, logger)
+	if err != nil {
+		logger.Error("Failed to create docker client", "error", err)
+		return 1
+	}
+	defer cli.Close()
+
+	plan, err := operations.Deploy(ctx, cli, config, logger)
+	if err != nil {
+		logger.Error("Failed to compute plan", "error", err)
+		return 1
+	}
+	if err := writePlan(os.Stdout, plan, *format); err != nil {
+		logger.Error("Failed to write plan", "error", err)
+		return 1
+	}
+	return 0
+}
+
+func writePlan(w io.Writer, plan *controller.Plan, format string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(plan)
+	}
+	return plan.WriteText(w)
+}
+
+// runHistory implements the "history" command: it prints the recorded deployment history.
+func runHistory(projects []model.Config, args []string, logger *slog.Logger) int {
+	historyFlags := flag.NewFlagSet("history", flag.ContinueOnError)
+	format := historyFlags.String("format", "text", "Output format: text or json.")
+	projectName := historyFlags.String("project", "", "Only show the history of this project.")
+	limit := historyFlags.Int("limit", 20, "Maximum number of entries per project; 0 shows all.")
+	if err := historyFlags.Parse(args); err != nil {
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		logger.Error("Unsupported output format", "format", *format)
+		return 2
+	}
+
+	entries := []operations.HistoryEntry{}
+	found := false
+	for _, project := range projects {
+		if *projectName != "" && project.ProjectName != *projectName {
+			continue
+		}
+		found = true
+		projectEntries, err := operations.ReadHistory(project, *limit)
+		if err != nil {
+			logger.Error("Failed to read deployment history", "project", project.ProjectName, "error", err)
+			return 1
+		}
+		entries = append(entries, projectEntries...)
+	}
+	if !found {
+		logger.Error("Unknown project", "project", *projectName)
+		return 2
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].StartedAt.After(entries[j].StartedAt)
+	})
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(entries); err != nil {
+			logger.Error("Failed to write history", "error", err)
+			return 1
+		}
+		return 0
+	}
+	if err := operations.WriteHistoryText(os.Stdout, entries); err != nil {
+		logger.Error("Failed to write history", "error", err)
+		return 1
 	}
+	return 0
 }
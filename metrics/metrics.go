@@ -0,0 +1,86 @@
+// Package metrics exposes Watcher's sync and reconciliation metrics in the Prometheus text format.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds Watcher's metrics. It is separate from the default registry so that only
+// Watcher's own metrics are served.
+var Registry = prometheus.NewRegistry()
+
+// DefBuckets are the default histogram buckets in seconds.
+var DefBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+var factory = promauto.With(Registry)
+
+var (
+	GitFetchDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "watcher_git_fetch_duration_seconds",
+		Help:    "Duration of cloning or fetching the project repository.",
+		Buckets: DefBuckets,
+	}, []string{"project"})
+	GitFetchFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "watcher_git_fetch_failures_total",
+		Help: "Number of failed clone or fetch operations.",
+	}, []string{"project"})
+	SyncedCommit = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "watcher_synced_commit_info",
+		Help: "Commit currently checked out in the deployment directory; the value is always 1.",
+	}, []string{"project", "commit"})
+	LastSyncTimestamp = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "watcher_last_sync_timestamp_seconds",
+		Help: "Unix time of the last successful clone or fetch.",
+	}, []string{"project"})
+
+	ReconcileDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "watcher_reconcile_duration_seconds",
+		Help:    "Duration of a deployment reconciliation.",
+		Buckets: DefBuckets,
+	}, []string{"project", "outcome"})
+	Reconciliations = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "watcher_reconciliations_total",
+		Help: "Number of deployment reconciliations by outcome (success or failure).",
+	}, []string{"project", "outcome"})
+	LastSuccessfulReconcile = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "watcher_last_successful_reconcile_timestamp_seconds",
+		Help: "Unix time of the last reconciliation that completed without errors.",
+	}, []string{"project"})
+
+	ServiceActions = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "watcher_service_actions_total",
+		Help: "Number of actions taken on services (create, recreate, start, remove).",
+	}, []string{"project", "service", "action"})
+	ImagePullDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "watcher_image_pull_duration_seconds",
+		Help:    "Duration of image pulls by outcome (success or failure).",
+		Buckets: DefBuckets,
+	}, []string{"outcome"})
+	HealthWaitTimeouts = factory.NewCounter(prometheus.CounterOpts{
+		Name: "watcher_health_wait_timeouts_total",
+		Help: "Number of times a container did not become healthy before the wait timed out.",
+	})
+)
+
+// Handler serves all of Watcher's metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// SetSyncedCommit records commit as the only synced commit of project.
+func SetSyncedCommit(project, commit string) {
+	SyncedCommit.DeletePartialMatch(prometheus.Labels{"project": project})
+	SyncedCommit.WithLabelValues(project, commit).Set(1)
+}
+
+// Outcome maps an error to the outcome label value.
+func Outcome(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
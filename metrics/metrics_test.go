@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSetSyncedCommit(t *testing.T) {
+	SetSyncedCommit("app", "aaa")
+	SetSyncedCommit("other", "ccc")
+	SetSyncedCommit("app", "bbb")
+
+	want := `
+# HELP watcher_synced_commit_info Commit currently checked out in the deployment directory; the value is always 1.
+# TYPE watcher_synced_commit_info gauge
+watcher_synced_commit_info{commit="bbb",project="app"} 1
+watcher_synced_commit_info{commit="ccc",project="other"} 1
+`
+	if err := testutil.CollectAndCompare(SyncedCommit, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: nil, want: "success"},
+		{err: errors.New("boom"), want: "failure"},
+	}
+	for _, tt := range tests {
+		if got := Outcome(tt.err); got != tt.want {
+			t.Errorf("Outcome(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestHandlerServesWatcherMetrics(t *testing.T) {
+	GitFetchFailures.WithLabelValues("app").Inc()
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := rec.Body.String()
+	if !strings.Contains(body, `watcher_git_fetch_failures_total{project="app"} 1`) {
+		t.Errorf("response does not contain the fetch failure counter:\n%s", body)
+	}
+	if strings.Contains(body, "go_goroutines") {
+		t.Errorf("response contains metrics from the default registry:\n%s", body)
+	}
+}
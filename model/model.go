@@ -4,14 +4,46 @@ import (
 	"github.com/go-git/go-git/v5/plumbing"
 )
 
+// Config is Watcher's configuration. The top-level fields describe a single project and
+// provide defaults for the entries in Projects when several projects are managed.
 type Config struct {
-	RepoURL          string
-	DeploymentDir    string
-	ComposeFile      string
-	TargetBranch     string
-	SSHKeyPath       string
-	CheckInterval    int
-	DockerAPIVersion string
+	ProjectName        string
+	RepoURL            string
+	DeploymentDir      string
+	ComposeFile        string
+	ComposeFiles       []string
+	TargetBranch       string
+	SSHKeyPath         string
+	GitUsername        string
+	GitTokenFile       string
+	GitTokenEnv        string
+	CheckInterval      int
+	HealthTimeout      int
+	DependencyFailure  string
+	Parallelism        int
+	ImageCheckInterval int
+	ImageUpdates       string
+	PullPolicy         string
+	ImageRetention     int
+	DockerAPIVersion   string
+	DryRun             bool
+	StateDir           string
+	HistoryLimit       int
+	ListenAddr         string
+	WebhookSecret      string
+	WebhookSecretFile  string
+	DockerConfigPath   string
+	SelfHeal           *bool // nil inherits the top-level value, so a project can also opt out
+	Registries         []RegistryCredential
+	Projects           []Config
+}
+
+// RegistryCredential holds the credentials for one container registry.
+type RegistryCredential struct {
+	Server       string
+	Username     string
+	Password     string
+	PasswordFile string
 }
 
 type RepoUpdate struct {
@@ -19,3 +51,11 @@ type RepoUpdate struct {
 	OldHash   plumbing.Hash
 	NewHash   plumbing.Hash
 }
+
+// DeploymentState is persisted between runs so rollbacks survive restarts.
+type DeploymentState struct {
+	LastGoodHash string   `json:"last_good_hash,omitempty"`
+	BadHashes    []string `json:"bad_hashes,omitempty"`
+	// Images lists the image IDs each service ran, most recent first, for image pruning.
+	Images map[string][]string `json:"images,omitempty"`
+}
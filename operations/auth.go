@@ -0,0 +1,104 @@
+package operations
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/sithukyaw666/watcher/model"
+)
+
+// defaultGitTokenEnv is the environment variable read for an HTTPS token when gitTokenEnv is not set.
+const defaultGitTokenEnv = "GIT_TOKEN"
+
+// resolveAuth picks the authentication method for the repository based on the scheme of repoURL:
+// HTTPS uses a token when one is configured and anonymous access otherwise, file:// and local
+// paths need no authentication, and everything else is treated as SSH.
+func resolveAuth(config model.Config, logger *slog.Logger) (transport.AuthMethod, error) {
+	switch {
+	case strings.HasPrefix(config.RepoURL, "https://"), strings.HasPrefix(config.RepoURL, "http://"):
+		return httpAuth(config, logger)
+	case isLocalRepo(config.RepoURL):
+		logger.Info("Using local repository, no authentication required.", "repo_url", config.RepoURL)
+		return nil, nil
+	default:
+		return sshAuth(config, logger)
+	}
+}
+
+// isLocalRepo reports whether url points to a repository on the local filesystem.
+func isLocalRepo(url string) bool {
+	if strings.HasPrefix(url, "file://") {
+		return true
+	}
+	if strings.Contains(url, "://") {
+		return false
+	}
+	// scp-like SSH syntax (git@host:path) has a colon before the first slash.
+	colon := strings.Index(url, ":")
+	slash := strings.Index(url, "/")
+	return colon < 0 || (slash >= 0 && slash < colon)
+}
+
+func httpAuth(config model.Config, logger *slog.Logger) (transport.AuthMethod, error) {
+	token, err := readGitToken(config)
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		logger.Info("No Git token configured, using anonymous HTTPS access.")
+		return nil, nil
+	}
+	username := config.GitUsername
+	if username == "" {
+		// Token based servers ignore the username, but basic auth requires a non-empty one.
+		username = "git"
+	}
+	logger.Info("Using token authentication over HTTPS.", "username", username)
+	return &http.BasicAuth{Username: username, Password: token}, nil
+}
+
+// readGitToken returns the HTTPS token from gitTokenFile, falling back to the environment.
+func readGitToken(config model.Config) (string, error) {
+	if config.GitTokenFile != "" {
+		data, err := os.ReadFile(config.GitTokenFile)
+		if err != nil {
+			return "", fmt.Errorf("could not read git token file: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	envName := config.GitTokenEnv
+	if envName == "" {
+		envName = defaultGitTokenEnv
+	}
+	return os.Getenv(envName), nil
+}
+
+func sshAuth(config model.Config, logger *slog.Logger) (transport.AuthMethod, error) {
+	var auth ssh.AuthMethod
+	var err error
+
+	if os.Getenv("SSH_AUTH_SOCK") != "" {
+		logger.Info("SSH Agent detected, attempting authentication.")
+		auth, err = ssh.NewSSHAgentAuth("git")
+		if err != nil {
+			logger.Warn("SSH agent auth failed, will attemp key file.", "error", err)
+		}
+	}
+	// Create the SSH authentication method with the private key
+	if auth == nil {
+		if config.SSHKeyPath == "" {
+			return nil, fmt.Errorf("no SSH agent found and sshKeyPath is not configured")
+		}
+		logger.Info("Using SSH key file for authentication.", "path", config.SSHKeyPath)
+		auth, err = ssh.NewPublicKeysFromFile("git", config.SSHKeyPath, "")
+		if err != nil {
+			return nil, fmt.Errorf("could not create SSH authentication: %w", err)
+		}
+	}
+	return auth, nil
+}
@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ShellCommand holds a command or entrypoint as a list of arguments. It accepts a list, or a
+// string that is split into arguments with shell quoting rules, as compose does. The string
+// is not run by a shell: variables, pipes and globs are passed on literally.
+type ShellCommand []string
+
+func (c *ShellCommand) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		args, err := splitShellWords(value.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", value.Line, err)
+		}
+		*c = args
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*c = list
+	return nil
+}
+
+// splitShellWords splits s into words like a POSIX shell: words are separated by unquoted
+// whitespace, single quotes preserve their content, double quotes preserve their content
+// except for backslash escapes of ", \, $ and `, and an unquoted backslash escapes the next
+// character.
+func splitShellWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	const (
+		unquoted = iota
+		singleQuoted
+		doubleQuoted
+	)
+	state := unquoted
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch state {
+		case singleQuoted:
+			if r == '\'' {
+				state = unquoted
+			} else {
+				word.WriteRune(r)
+			}
+		case doubleQuoted:
+			switch {
+			case r == '"':
+				state = unquoted
+			case r == '\\' && i+1 < len(runes) && strings.ContainsRune("\"\\$`", runes[i+1]):
+				i++
+				word.WriteRune(runes[i])
+			default:
+				word.WriteRune(r)
+			}
+		default:
+			switch {
+			case r == ' ' || r == '\t' || r == '\n':
+				if inWord {
+					words = append(words, word.String())
+					word.Reset()
+					inWord = false
+				}
+				continue
+			case r == '\'':
+				state = singleQuoted
+			case r == '"':
+				state = doubleQuoted
+			case r == '\\':
+				if i+1 == len(runes) {
+					return nil, fmt.Errorf("command ends with an unescaped backslash: %s", s)
+				}
+				i++
+				if runes[i] == '\n' {
+					// A line continuation joins lines without starting a word.
+					continue
+				}
+				word.WriteRune(runes[i])
+			default:
+				word.WriteRune(r)
+			}
+		}
+		inWord = true
+	}
+	if state != unquoted {
+		return nil, fmt.Errorf("command has an unterminated quote: %s", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// Healthcheck test forms, as in Docker's HEALTHCHECK.
+const (
+	healthTestNone     = "NONE"
+	healthTestCmd      = "CMD"
+	healthTestCmdShell = "CMD-SHELL"
+)
+
+// HealthCheckTest holds a healthcheck test in the form Docker expects: ["CMD", args...],
+// ["CMD-SHELL", command] or ["NONE"]. A string is run with the container's shell.
+type HealthCheckTest []string
+
+func (t *HealthCheckTest) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		if value.Value == healthTestNone {
+			*t = HealthCheckTest{healthTestNone}
+		} else {
+			*t = HealthCheckTest{healthTestCmdShell, value.Value}
+		}
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		*t = nil
+		return nil
+	}
+	switch list[0] {
+	case healthTestNone:
+		list = list[:1]
+	case healthTestCmd:
+	case healthTestCmdShell:
+		list = []string{healthTestCmdShell, strings.Join(list[1:], " ")}
+	default:
+		return fmt.Errorf("line %d: healthcheck test must start with %s, %s or %s", value.Line, healthTestCmd, healthTestCmdShell, healthTestNone)
+	}
+	*t = list
+	return nil
+}
+
+func (h *HealthCheck) UnmarshalYAML(value *yaml.Node) error {
+	type plain HealthCheck
+	if err := value.Decode((*plain)(h)); err != nil {
+		return err
+	}
+	if h.Disable {
+		h.Test = HealthCheckTest{healthTestNone}
+	}
+	return nil
+}
+
+// disabled reports whether the healthcheck turns off the image's healthcheck.
+func (h *HealthCheck) disabled() bool {
+	return len(h.Test) > 0 && h.Test[0] == healthTestNone
+}
+
+// hasHealthCheck reports whether the service defines a healthcheck Watcher can wait for.
+func (s *Service) hasHealthCheck() bool {
+	return s.HealthCheck != nil && len(s.HealthCheck.Test) > 0 && !s.HealthCheck.disabled()
+}
@@ -0,0 +1,176 @@
+package controller
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSplitShellWords(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr string
+	}{
+		{in: "", want: nil},
+		{in: "   ", want: nil},
+		{in: "npm start", want: []string{"npm", "start"}},
+		{in: "  a \t b\nc  ", want: []string{"a", "b", "c"}},
+		{in: `echo 'hello world'`, want: []string{"echo", "hello world"}},
+		{in: `echo "hello world"`, want: []string{"echo", "hello world"}},
+		{in: `echo ''`, want: []string{"echo", ""}},
+		{in: `echo ""`, want: []string{"echo", ""}},
+		{in: `a'b'"c"d`, want: []string{"abcd"}},
+		{in: `echo 'a\nb "c"'`, want: []string{"echo", `a\nb "c"`}},
+		{in: `echo "say \"hi\" \$HOME \\ \n"`, want: []string{"echo", `say "hi" $HOME \ \n`}},
+		{in: `echo "it's"`, want: []string{"echo", "it's"}},
+		{in: `echo hello\ world`, want: []string{"echo", "hello world"}},
+		{in: `echo \"quoted\"`, want: []string{"echo", `"quoted"`}},
+		{in: "a \\\n b", want: []string{"a", "b"}},
+		{in: "a\\\nb", want: []string{"ab"}},
+		{in: "sh -c 'echo $HOME | wc -c'", want: []string{"sh", "-c", "echo $HOME | wc -c"}},
+
+		{in: `echo 'unterminated`, wantErr: "command has an unterminated quote: echo 'unterminated"},
+		{in: `echo "unterminated`, wantErr: `command has an unterminated quote: echo "unterminated`},
+		{in: `echo trailing\`, wantErr: `command ends with an unescaped backslash: echo trailing\`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := splitShellWords(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("splitShellWords(%q) error = %v, want %q", tt.in, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("splitShellWords(%q) unexpected error: %v", tt.in, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitShellWords(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShellCommandUnmarshal(t *testing.T) {
+	tests := []struct {
+		doc     string
+		want    ShellCommand
+		wantErr string
+	}{
+		{doc: `npm run "build all"`, want: ShellCommand{"npm", "run", "build all"}},
+		{doc: `[npm, run, "build all"]`, want: ShellCommand{"npm", "run", "build all"}},
+		{doc: `[]`, want: ShellCommand{}},
+		{doc: `"echo 'oops"`, wantErr: "line 1: command has an unterminated quote"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.doc, func(t *testing.T) {
+			var got ShellCommand
+			err := yaml.Unmarshal([]byte(tt.doc), &got)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("unmarshalling %q: error = %v, want error containing %q", tt.doc, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unmarshalling %q: %v", tt.doc, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unmarshalling %q = %q, want %q", tt.doc, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHealthCheckTestUnmarshal(t *testing.T) {
+	tests := []struct {
+		doc     string
+		want    HealthCheckTest
+		wantErr string
+	}{
+		{doc: `curl -f http://localhost`, want: HealthCheckTest{"CMD-SHELL", "curl -f http://localhost"}},
+		{doc: `NONE`, want: HealthCheckTest{"NONE"}},
+		{doc: `[NONE]`, want: HealthCheckTest{"NONE"}},
+		{doc: `[NONE, ignored]`, want: HealthCheckTest{"NONE"}},
+		{doc: `[CMD, curl, -f, "http://localhost"]`, want: HealthCheckTest{"CMD", "curl", "-f", "http://localhost"}},
+		{doc: `[CMD-SHELL, "curl -f http://localhost"]`, want: HealthCheckTest{"CMD-SHELL", "curl -f http://localhost"}},
+		{doc: `[CMD-SHELL, curl, -f, "http://localhost"]`, want: HealthCheckTest{"CMD-SHELL", "curl -f http://localhost"}},
+		{doc: `[]`, want: nil},
+		{doc: `[curl, -f, "http://localhost"]`, wantErr: "line 1: healthcheck test must start with CMD, CMD-SHELL or NONE"},
+		{doc: `{cmd: curl}`, wantErr: "cannot unmarshal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.doc, func(t *testing.T) {
+			var got HealthCheckTest
+			err := yaml.Unmarshal([]byte(tt.doc), &got)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("unmarshalling %q: error = %v, want error containing %q", tt.doc, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unmarshalling %q: %v", tt.doc, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unmarshalling %q = %q, want %q", tt.doc, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHealthCheckUnmarshal(t *testing.T) {
+	tests := []struct {
+		name         string
+		doc          string
+		wantTest     HealthCheckTest
+		wantDisabled bool
+	}{
+		{
+			name:     "test with options",
+			doc:      "{test: [CMD, true], interval: 5s, retries: 3}",
+			wantTest: HealthCheckTest{"CMD", "true"},
+		},
+		{
+			name:         "disable replaces the test",
+			doc:          "{disable: true, test: [CMD, true]}",
+			wantTest:     HealthCheckTest{"NONE"},
+			wantDisabled: true,
+		},
+		{
+			name:         "disable without a test",
+			doc:          "{disable: true}",
+			wantTest:     HealthCheckTest{"NONE"},
+			wantDisabled: true,
+		},
+		{
+			name:     "disable false keeps the test",
+			doc:      "{disable: false, test: exit 0}",
+			wantTest: HealthCheckTest{"CMD-SHELL", "exit 0"},
+		},
+		{
+			name:         "test NONE disables",
+			doc:          "{test: [NONE]}",
+			wantTest:     HealthCheckTest{"NONE"},
+			wantDisabled: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got HealthCheck
+			if err := yaml.Unmarshal([]byte(tt.doc), &got); err != nil {
+				t.Fatalf("unmarshalling %q: %v", tt.doc, err)
+			}
+			if !reflect.DeepEqual(got.Test, tt.wantTest) {
+				t.Errorf("test = %q, want %q", got.Test, tt.wantTest)
+			}
+			if got.disabled() != tt.wantDisabled {
+				t.Errorf("disabled() = %v, want %v", got.disabled(), tt.wantDisabled)
+			}
+		})
+	}
+}
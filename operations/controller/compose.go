@@ -1,21 +1,131 @@
 package controller
 
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
 type Compose struct {
+	// Version and Name are accepted for compatibility. The project name is taken from
+	// Watcher's configuration.
+	Version  string             `yaml:"version,omitempty"`
+	Name     string             `yaml:"name,omitempty"`
 	Services map[string]Service `yaml:"services"`
 	Networks map[string]Network `yaml:"networks"`
 	Volumes  map[string]Volume  `yaml:"volumes"`
 }
 
 type Service struct {
-	Image         string       `yaml:"image"`
-	ContainerName string       `yaml:"container_name"`
-	Environment   []string     `yaml:"environment"`
-	Ports         []string     `yaml:"ports"`
-	Volumes       []string     `yaml:"volumes"`
-	Networks      []string     `yaml:"networks"`
-	Command       []string     `yaml:"command"`
-	DependsOn     []string     `yaml:"depends_on,omitempty"`
-	HealthCheck   *HealthCheck `yaml:"healthcheck,omitempty"`
+	Image           string            `yaml:"image"`
+	PullPolicy      string            `yaml:"pull_policy,omitempty"`
+	ContainerName   string            `yaml:"container_name"`
+	Environment     Environment       `yaml:"environment"`
+	Labels          Labels            `yaml:"labels,omitempty"`
+	EnvFile         EnvFiles          `yaml:"env_file,omitempty"`
+	Ports           Ports             `yaml:"ports"`
+	Volumes         []ServiceVolume   `yaml:"volumes"`
+	Networks        []string          `yaml:"networks"`
+	Command         ShellCommand      `yaml:"command"`
+	Entrypoint      ShellCommand      `yaml:"entrypoint,omitempty"`
+	WorkingDir      string            `yaml:"working_dir,omitempty"`
+	User            string            `yaml:"user,omitempty"`
+	Hostname        string            `yaml:"hostname,omitempty"`
+	Domainname      string            `yaml:"domainname,omitempty"`
+	Privileged      bool              `yaml:"privileged,omitempty"`
+	CapAdd          []string          `yaml:"cap_add,omitempty"`
+	CapDrop         []string          `yaml:"cap_drop,omitempty"`
+	SecurityOpt     []string          `yaml:"security_opt,omitempty"`
+	Devices         []string          `yaml:"devices,omitempty"`
+	DNS             StringList        `yaml:"dns,omitempty"`
+	DNSSearch       StringList        `yaml:"dns_search,omitempty"`
+	ExtraHosts      ExtraHosts        `yaml:"extra_hosts,omitempty"`
+	Sysctls         Sysctls           `yaml:"sysctls,omitempty"`
+	Init            *bool             `yaml:"init,omitempty"`
+	ReadOnly        bool              `yaml:"read_only,omitempty"`
+	Tmpfs           StringList        `yaml:"tmpfs,omitempty"`
+	StopSignal      string            `yaml:"stop_signal,omitempty"`
+	StopGracePeriod string            `yaml:"stop_grace_period,omitempty"`
+	Tty             bool              `yaml:"tty,omitempty"`
+	StdinOpen       bool              `yaml:"stdin_open,omitempty"`
+	Logging         *LoggingConfig    `yaml:"logging,omitempty"`
+	NetworkMode     string            `yaml:"network_mode,omitempty"`
+	Pid             string            `yaml:"pid,omitempty"`
+	Ipc             string            `yaml:"ipc,omitempty"`
+	DependsOn       DependsOn         `yaml:"depends_on,omitempty"`
+	HealthCheck     *HealthCheck      `yaml:"healthcheck,omitempty"`
+	Restart         string            `yaml:"restart,omitempty"`
+	Deploy          *DeployConfig     `yaml:"deploy,omitempty"`
+	CPUs            string            `yaml:"cpus,omitempty"`
+	MemLimit        string            `yaml:"mem_limit,omitempty"`
+	PidsLimit       int64             `yaml:"pids_limit,omitempty"`
+	ShmSize         string            `yaml:"shm_size,omitempty"`
+	Ulimits         Ulimits           `yaml:"ulimits,omitempty"`
+	OomScoreAdj     int               `yaml:"oom_score_adj,omitempty"`
+	XWatcher        *WatcherExtension `yaml:"x-watcher,omitempty"`
+}
+
+// Labels holds a service's container labels. It accepts both the list syntax (- key=value)
+// and the map syntax (key: value).
+type Labels map[string]string
+
+func (l *Labels) UnmarshalYAML(value *yaml.Node) error {
+	labels, err := decodeKeyValues(value, "labels")
+	if err != nil {
+		return err
+	}
+	*l = labels
+	return nil
+}
+
+// decodeKeyValues decodes a list of key=value entries or a mapping into a map.
+func decodeKeyValues(value *yaml.Node, name string) (map[string]string, error) {
+	values := make(map[string]string)
+	switch value.Kind {
+	case yaml.SequenceNode:
+		var list []string
+		if err := value.Decode(&list); err != nil {
+			return nil, err
+		}
+		for _, item := range list {
+			k, v, _ := strings.Cut(item, "=")
+			values[k] = v
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			values[value.Content[i].Value] = value.Content[i+1].Value
+		}
+	default:
+		return nil, fmt.Errorf("line %d: %s must be a list or a map", value.Line, name)
+	}
+	return values, nil
+}
+
+// Update strategies for re-creating a service.
+const (
+	// UpdateStopFirst stops and removes the old container before creating the new one.
+	UpdateStopFirst = "stop-first"
+	// UpdateStartFirst starts the new container and waits for it before removing the old one.
+	UpdateStartFirst = "start-first"
+)
+
+// WatcherExtension holds Watcher specific service settings from the x-watcher extension.
+type WatcherExtension struct {
+	UpdateStrategy string `yaml:"update_strategy,omitempty"`
+	// HealthTimeout overrides how long Watcher waits for the service to become healthy, e.g. "2m".
+	HealthTimeout string `yaml:"health_timeout,omitempty"`
+	// CompletionTimeout bounds how long dependents wait for the service to complete, e.g. "30m".
+	// Without it they wait as long as the reconciliation runs.
+	CompletionTimeout string `yaml:"completion_timeout,omitempty"`
+}
+
+// updateStrategy returns the service's update strategy, defaulting to stop-first.
+func (s *Service) updateStrategy() string {
+	if s.XWatcher != nil && s.XWatcher.UpdateStrategy != "" {
+		return s.XWatcher.UpdateStrategy
+	}
+	return UpdateStopFirst
 }
 
 type Network struct {
@@ -31,9 +141,11 @@ type Volume struct {
 }
 
 type HealthCheck struct {
-	Test        []string `yaml:"test,omitempty"`
-	Interval    string   `yaml:"interval,omitempty"`
-	Timeout     string   `yaml:"timeout,omitempty"`
-	Retries     int      `yaml:"retries,omitempty"`
-	StartPeriod string   `yaml:"start_period,omitempty"`
+	Test        HealthCheckTest `yaml:"test,omitempty"`
+	Interval    string          `yaml:"interval,omitempty"`
+	Timeout     string          `yaml:"timeout,omitempty"`
+	Retries     int             `yaml:"retries,omitempty"`
+	StartPeriod string          `yaml:"start_period,omitempty"`
+	// Disable turns off the image's healthcheck, like a test of ["NONE"].
+	Disable bool `yaml:"disable,omitempty"`
 }
@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/client"
+	"gopkg.in/yaml.v3"
+)
+
+// Conditions a service can wait for before its dependents are reconciled.
+const (
+	// ConditionStarted only requires the dependency's container to exist and have been started.
+	ConditionStarted = "service_started"
+	// ConditionHealthy waits for the dependency's healthcheck to pass.
+	ConditionHealthy = "service_healthy"
+	// ConditionCompleted waits for a one-shot dependency, such as a migration, to exit with code 0.
+	ConditionCompleted = "service_completed_successfully"
+)
+
+// Dependency is one entry of a service's depends_on.
+type Dependency struct {
+	Service   string
+	Condition string
+	// Required dependencies that are missing or fail their condition fail the dependent service.
+	// Optional ones are skipped with a warning.
+	Required bool
+	// Restart restarts the dependent service whenever Watcher re-creates or restarts the dependency.
+	Restart bool
+}
+
+// DependsOn holds a service's dependencies in either the short list form
+// ("depends_on: [db]") or the long map form ("depends_on: {db: {condition: service_healthy}}").
+type DependsOn []Dependency
+
+// dependencyConfig is a depends_on entry in the long syntax.
+type dependencyConfig struct {
+	Condition string `yaml:"condition"`
+	Required  *bool  `yaml:"required"`
+	Restart   bool   `yaml:"restart"`
+}
+
+func (DependsOn) yamlSchema() any { return map[string]dependencyConfig{} }
+
+// UnmarshalYAML accepts both depends_on forms. Short form entries use the service_started
+// condition and are required.
+func (d *DependsOn) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		var names []string
+		if err := node.Decode(&names); err != nil {
+			return err
+		}
+		deps := make(DependsOn, 0, len(names))
+		for _, name := range names {
+			deps = append(deps, Dependency{Service: name, Condition: ConditionStarted, Required: true})
+		}
+		*d = deps
+		return nil
+	case yaml.MappingNode:
+		deps := make(DependsOn, 0, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			var long dependencyConfig
+			if err := node.Content[i+1].Decode(&long); err != nil {
+				return fmt.Errorf("depends_on '%s': %w", node.Content[i].Value, err)
+			}
+			dep := Dependency{Service: node.Content[i].Value, Condition: long.Condition, Required: true, Restart: long.Restart}
+			if dep.Condition == "" {
+				dep.Condition = ConditionStarted
+			}
+			if long.Required != nil {
+				dep.Required = *long.Required
+			}
+			deps = append(deps, dep)
+		}
+		*d = deps
+		return nil
+	}
+	return fmt.Errorf("line %d: depends_on must be a list or a map", node.Line)
+}
+
+// validate checks the conditions of every dependency.
+func (d DependsOn) validate() error {
+	for _, dep := range d {
+		switch dep.Condition {
+		case ConditionStarted, ConditionHealthy, ConditionCompleted:
+		default:
+			return fmt.Errorf("dependency '%s' has unsupported condition '%s'", dep.Service, dep.Condition)
+		}
+	}
+	return nil
+}
+
+// waitForDependency blocks until the dependency's container satisfies dep.Condition.
+func waitForDependency(ctx context.Context, cli *client.Client, dep Dependency, depService *Service, depContainer container.Summary, opts ApplyOptions, logger *slog.Logger) error {
+	switch dep.Condition {
+	case ConditionHealthy:
+		if !depService.hasHealthCheck() {
+			// The image may define a HEALTHCHECK, in which case Docker reports a health status.
+			inspect, err := cli.ContainerInspect(ctx, depContainer.ID)
+			if err != nil {
+				return fmt.Errorf("failed to inspect container %s: %w", depContainer.ID, err)
+			}
+			if inspect.State == nil || inspect.State.Health == nil {
+				return fmt.Errorf("service '%s' has no healthcheck", dep.Service)
+			}
+		}
+		return waitForHealthCheck(ctx, cli, depContainer.ID, healthWaitTimeout(depService, opts), logger)
+	case ConditionCompleted:
+		return waitForCompletion(ctx, cli, depContainer.ID, completionWaitTimeout(depService), logger)
+	}
+	return nil
+}
+
+// completionWaitTimeout returns the service's x-watcher completion_timeout, or zero when the
+// wait is only bounded by the context.
+func completionWaitTimeout(service *Service) time.Duration {
+	if service.XWatcher == nil || service.XWatcher.CompletionTimeout == "" {
+		return 0
+	}
+	d, _ := time.ParseDuration(service.XWatcher.CompletionTimeout)
+	return d
+}
+
+// waitForCompletion waits for a container to stop and checks that it exited with code 0. A
+// positive timeout bounds the wait.
+func waitForCompletion(ctx context.Context, cli *client.Client, containerID string, timeout time.Duration, logger *slog.Logger) error {
+	logger.Info("Waiting for container to complete", "container_id", containerID[:12])
+	waitCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	resultC, errC := cli.ContainerWait(waitCtx, containerID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errC:
+		if ctx.Err() == nil && errors.Is(waitCtx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %s waiting for container %s to complete", timeout, containerID)
+		}
+		return fmt.Errorf("failed to wait for container %s: %w", containerID, err)
+	case result := <-resultC:
+		if result.Error != nil {
+			return fmt.Errorf("failed to wait for container %s: %s", containerID, result.Error.Message)
+		}
+		if result.StatusCode != 0 {
+			return fmt.Errorf("container %s exited with code %d", containerID, result.StatusCode)
+		}
+		logger.Info("Container completed successfully", "container_id", containerID[:12])
+		return nil
+	}
+}
+
+// oneShotServices returns the services other services wait on to complete. Their containers are
+// expected to exit, so an exited container with code 0 is not started again.
+func oneShotServices(compose *Compose) map[string]bool {
+	oneShot := make(map[string]bool)
+	for _, service := range compose.Services {
+		for _, dep := range service.DependsOn {
+			if dep.Condition == ConditionCompleted {
+				oneShot[dep.Service] = true
+			}
+		}
+	}
+	return oneShot
+}
+
+// exitedSuccessfully reports whether a stopped container exited with code 0.
+func exitedSuccessfully(ctx context.Context, cli *client.Client, c container.Summary) bool {
+	if c.State != container.StateExited {
+		return false
+	}
+	inspect, err := cli.ContainerInspect(ctx, c.ID)
+	return err == nil && inspect.State != nil && inspect.State.ExitCode == 0
+}
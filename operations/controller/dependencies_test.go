@@ -0,0 +1,25 @@
+package controller
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompletionWaitTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		service Service
+		want    time.Duration
+	}{
+		{name: "no extension", service: Service{}, want: 0},
+		{name: "health timeout does not apply", service: Service{XWatcher: &WatcherExtension{HealthTimeout: "2m"}}, want: 0},
+		{name: "completion timeout", service: Service{XWatcher: &WatcherExtension{CompletionTimeout: "30m"}}, want: 30 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := completionWaitTimeout(&tt.service); got != tt.want {
+				t.Errorf("completionWaitTimeout() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -11,10 +11,15 @@ import (
 
 // Apply is the main entry point for Docker operations. It lists running containers,
 // builds the actual state map, and then delegates service reconciliation to ReconcileServices.
-func Apply(ctx context.Context, cli *client.Client, projectName string, compose *Compose, logger *slog.Logger) error {
-
-	ReconcileVolumes(ctx, cli, projectName, compose.Volumes, logger)
-	ReconcileNetworks(ctx, cli, projectName, compose.Networks, logger)
+// The returned plan lists every action taken, or only intended when opts.DryRun is set.
+// When opts.Services is set only those services are repaired.
+func Apply(ctx context.Context, cli *client.Client, projectName string, compose *Compose, opts ApplyOptions, logger *slog.Logger) (*Plan, error) {
+	plan := &Plan{Project: projectName, DryRun: opts.DryRun, Actions: []Action{}}
+
+	if len(opts.Services) == 0 {
+		ReconcileVolumes(ctx, cli, projectName, compose.Volumes, plan, logger)
+		ReconcileNetworks(ctx, cli, projectName, compose.Networks, plan, logger)
+	}
 	projectFilter := filters.NewArgs(filters.Arg("label", "com.docker.compose.project="+projectName))
 	runningContainers, err := cli.ContainerList(ctx, container.ListOptions{
 		All:     true,
@@ -22,12 +27,24 @@ func Apply(ctx context.Context, cli *client.Client, projectName string, compose
 	})
 
 	if err != nil {
-		return err
+		return plan, err
 	}
 
 	actualState := make(map[string]container.Summary)
 
 	for _, c := range runningContainers {
+		if isReplacement(c) {
+			// Left behind by an interrupted start-first update. The service is reconciled from its
+			// regular container, or re-created if the update got as far as removing it.
+			plan.add(KindService, c.Labels["com.docker.compose.service"], ActionRemove, "stale start-first replacement")
+			if !opts.DryRun {
+				logger.Warn("Removing stale replacement container", "container_id", c.ID[:12], "names", c.Names)
+				if err := cli.ContainerRemove(ctx, c.ID, container.RemoveOptions{Force: true}); err != nil {
+					logger.Error("Failed to remove stale replacement container", "container_id", c.ID[:12], "error", err)
+				}
+			}
+			continue
+		}
 		serviceName := c.Labels["com.docker.compose.service"]
 		logger.Info("Found existing container for service", "service_name", serviceName, "container_id", c.ID[:12], "image", c.Image)
 		if serviceName != "" {
@@ -38,5 +55,5 @@ func Apply(ctx context.Context, cli *client.Client, projectName string, compose
 	logger.Info("Found containers for project", "container_count", len(actualState), "project_name", projectName)
 
 	// Delegate service reconciliation to the dedicated function
-	return ReconcileServices(ctx, cli, projectName, compose, actualState, logger)
+	return plan, ReconcileServices(ctx, cli, projectName, compose, actualState, opts, plan, logger)
 }
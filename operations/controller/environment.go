@@ -0,0 +1,204 @@
+package controller
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Environment holds a service's environment as "KEY=value" entries. It accepts both the
+// list syntax (- KEY=value) and the map syntax (KEY: value).
+type Environment []string
+
+func (e *Environment) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.SequenceNode:
+		var list []string
+		if err := value.Decode(&list); err != nil {
+			return err
+		}
+		*e = list
+	case yaml.MappingNode:
+		env := make(Environment, 0, len(value.Content)/2)
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			k, v := value.Content[i], value.Content[i+1]
+			if v.Tag == "!!null" {
+				// "KEY:" without a value is resolved from Watcher's environment later on.
+				env = append(env, k.Value)
+			} else {
+				env = append(env, k.Value+"="+v.Value)
+			}
+		}
+		*e = env
+	default:
+		return fmt.Errorf("line %d: environment must be a list or a map", value.Line)
+	}
+	return nil
+}
+
+// EnvFile is an env_file entry. Files are required unless marked otherwise.
+type EnvFile struct {
+	Path     string `yaml:"path"`
+	Required *bool  `yaml:"required,omitempty"`
+}
+
+// EnvFiles accepts env_file as a single path, a list of paths or a list of {path, required}.
+type EnvFiles []EnvFile
+
+func (f *EnvFiles) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		*f = EnvFiles{{Path: value.Value}}
+	case yaml.SequenceNode:
+		files := make(EnvFiles, 0, len(value.Content))
+		for _, item := range value.Content {
+			if item.Kind == yaml.ScalarNode {
+				files = append(files, EnvFile{Path: item.Value})
+				continue
+			}
+			var file EnvFile
+			if err := item.Decode(&file); err != nil {
+				return err
+			}
+			files = append(files, file)
+		}
+		*f = files
+	default:
+		return fmt.Errorf("line %d: env_file must be a string or a list", value.Line)
+	}
+	return nil
+}
+
+// resolveEnvironment computes a service's final environment: env_file entries in order, then
+// the environment section, with later definitions overriding earlier ones. Entries without a
+// value are taken from Watcher's own environment and dropped when unset there.
+func resolveEnvironment(service *Service, baseDir string) error {
+	var keys []string
+	values := make(map[string]string)
+	set := func(key, value string) {
+		if _, exists := values[key]; !exists {
+			keys = append(keys, key)
+		}
+		values[key] = value
+	}
+
+	for _, file := range service.EnvFile {
+		path := file.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+		entries, err := readEnvFile(path)
+		if errors.Is(err, os.ErrNotExist) && file.Required != nil && !*file.Required {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("env_file: %w", err)
+		}
+		for _, entry := range entries {
+			set(entry[0], entry[1])
+		}
+	}
+
+	for _, entry := range service.Environment {
+		key, value, hasValue := strings.Cut(entry, "=")
+		if !hasValue {
+			envValue, ok := os.LookupEnv(key)
+			if !ok {
+				continue
+			}
+			value = envValue
+		}
+		set(key, value)
+	}
+
+	if len(keys) == 0 {
+		service.Environment = nil
+		return nil
+	}
+	env := make(Environment, 0, len(keys))
+	for _, key := range keys {
+		env = append(env, key+"="+values[key])
+	}
+	service.Environment = env
+	return nil
+}
+
+// readEnvFile parses a file of KEY=value lines. Blank lines and lines starting with # are
+// ignored, an optional "export " prefix is allowed and values may be single or double quoted.
+func readEnvFile(path string) ([][2]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries [][2]string
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok {
+			// A bare KEY is taken from Watcher's environment, like in docker compose.
+			if envValue, set := os.LookupEnv(key); set {
+				entries = append(entries, [2]string{key, envValue})
+			}
+			continue
+		}
+		if key == "" {
+			return nil, fmt.Errorf("%s:%d: missing variable name", path, lineNo)
+		}
+		entries = append(entries, [2]string{key, unquoteEnvValue(strings.TrimSpace(value))})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return entries, nil
+}
+
+func unquoteEnvValue(v string) string {
+	if len(v) >= 2 {
+		switch {
+		case v[0] == '\'' && v[len(v)-1] == '\'':
+			return v[1 : len(v)-1]
+		case v[0] == '"' && v[len(v)-1] == '"':
+			return strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\"`, `"`, `\\`, `\`).Replace(v[1 : len(v)-1])
+		}
+	}
+	// Unquoted values may carry an inline comment.
+	if i := strings.Index(v, " #"); i >= 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+	return v
+}
+
+// interpolationLookup resolves variables from Watcher's environment first, then from the
+// .env file next to the compose file, matching docker compose precedence.
+func interpolationLookup(baseDir string) (lookupFunc, error) {
+	dotEnv := make(map[string]string)
+	entries, err := readEnvFile(filepath.Join(baseDir, ".env"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read .env file: %w", err)
+	}
+	for _, entry := range entries {
+		dotEnv[entry[0]] = entry[1]
+	}
+	return func(name string) (string, bool) {
+		if value, ok := os.LookupEnv(name); ok {
+			return value, true
+		}
+		value, ok := dotEnv[name]
+		return value, ok
+	}, nil
+}
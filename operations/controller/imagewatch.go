@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/containerd/errdefs"
+	"github.com/moby/moby/client"
+)
+
+// ImageUpdateLabel opts a service in ("true") or out ("false") of image update checks.
+const ImageUpdateLabel = "watcher.image-update"
+
+// PullUpdatedImages compares the registry digest of each watched service's image with the
+// local copy and pulls the images that changed. When optIn is set only services labeled
+// watcher.image-update=true are watched, otherwise every service not labeled "false".
+// It returns the services whose image was pulled, sorted by name. Images that cannot be
+// looked up in a registry, such as locally built ones, are skipped.
+func PullUpdatedImages(ctx context.Context, cli *client.Client, compose *Compose, optIn bool, registryAuth *RegistryAuth, logger *slog.Logger) ([]string, error) {
+	var updated []string
+	var errs []error
+	checked := make(map[string]bool)
+	pulled := make(map[string]bool)
+	for _, serviceName := range slices.Sorted(maps.Keys(compose.Services)) {
+		service := compose.Services[serviceName]
+		if !watchesImage(&service, optIn) {
+			continue
+		}
+		// Digest references cannot move.
+		if strings.Contains(service.Image, "@") {
+			continue
+		}
+		if !checked[service.Image] {
+			checked[service.Image] = true
+			changed, err := remoteImageChanged(ctx, cli, service.Image, registryAuth)
+			if err != nil {
+				logger.Warn("Could not check image for updates", "service_name", serviceName, "image", service.Image, "error", err)
+				continue
+			}
+			if !changed {
+				logger.Info("Image is up to date", "service_name", serviceName, "image", service.Image)
+				continue
+			}
+			logger.Info("New image digest found. Pulling...", "service_name", serviceName, "image", service.Image)
+			if err := pullImage(ctx, cli, service.Image, registryAuth, logger); err != nil {
+				logger.Error("Failed to pull updated image", "image", service.Image, "error", err)
+				errs = append(errs, fmt.Errorf("failed to pull image %s: %w", service.Image, err))
+				continue
+			}
+			pulled[service.Image] = true
+		}
+		if pulled[service.Image] {
+			updated = append(updated, serviceName)
+		}
+	}
+	return updated, errors.Join(errs...)
+}
+
+func watchesImage(service *Service, optIn bool) bool {
+	switch service.Labels[ImageUpdateLabel] {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return !optIn
+}
+
+// remoteImageChanged reports whether the registry's manifest digest for imageRef differs from
+// the digests of the local image. Only the manifest is requested, no layers are downloaded.
+func remoteImageChanged(ctx context.Context, cli *client.Client, imageRef string, registryAuth *RegistryAuth) (bool, error) {
+	encodedAuth, err := registryAuth.EncodedAuth(imageRef)
+	if err != nil {
+		return false, fmt.Errorf("could not resolve registry credentials: %w", err)
+	}
+	remote, err := cli.DistributionInspect(ctx, imageRef, encodedAuth)
+	if err != nil {
+		return false, fmt.Errorf("could not inspect registry manifest: %w", err)
+	}
+	local, err := cli.ImageInspect(ctx, imageRef)
+	if errdefs.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not inspect local image: %w", err)
+	}
+	digest := "@" + remote.Descriptor.Digest.String()
+	for _, repoDigest := range local.RepoDigests {
+		if strings.HasSuffix(repoDigest, digest) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
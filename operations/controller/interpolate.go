@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// lookupFunc resolves a variable for interpolation.
+type lookupFunc func(name string) (string, bool)
+
+// interpolateNode substitutes variables in every scalar value of a YAML document, following
+// docker compose semantics. Mapping keys are left untouched.
+func interpolateNode(node *yaml.Node, lookup lookupFunc) error {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			if err := interpolateNode(child, lookup); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := interpolateNode(node.Content[i], lookup); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "$") {
+			return nil
+		}
+		value, err := interpolate(node.Value, lookup)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		node.Value = value
+		if node.Style == 0 {
+			// Resolve the type of unquoted values again, so "retries: ${RETRIES}" fills an int.
+			// A value that resolves to null, such as an empty one, stays a string.
+			node.Tag = ""
+			if node.Tag = node.ShortTag(); node.Tag == "!!null" {
+				node.Tag = "!!str"
+			}
+		}
+	}
+	return nil
+}
+
+// interpolate expands $VAR, ${VAR}, ${VAR:-default}, ${VAR-default}, ${VAR:?error}, ${VAR?error},
+// ${VAR:+replacement} and ${VAR+replacement} in s. "$$" is an escaped "$". Unset variables
+// without a default expand to an empty string.
+func interpolate(s string, lookup lookupFunc) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		next := s[i+1]
+		switch {
+		case next == '$':
+			b.WriteByte('$')
+			i++
+		case next == '{':
+			end := matchingBrace(s, i+1)
+			if end < 0 {
+				return "", fmt.Errorf("invalid interpolation format for %q: missing closing brace", s)
+			}
+			value, err := expandBraced(s[i+2:end], lookup)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(value)
+			i = end
+		case isNameStart(next):
+			j := i + 1
+			for j < len(s) && isNameChar(s[j]) {
+				j++
+			}
+			value, _ := lookup(s[i+1 : j])
+			b.WriteString(value)
+			i = j - 1
+		default:
+			b.WriteByte('$')
+		}
+	}
+	return b.String(), nil
+}
+
+// matchingBrace returns the index of the brace closing the one at open, allowing nested ${...}.
+func matchingBrace(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// expandBraced expands the contents of a ${...} expression.
+func expandBraced(expr string, lookup lookupFunc) (string, error) {
+	n := 0
+	for n < len(expr) && isNameChar(expr[n]) {
+		n++
+	}
+	name, rest := expr[:n], expr[n:]
+	if name == "" || !isNameStart(name[0]) {
+		return "", fmt.Errorf("invalid interpolation format for ${%s}", expr)
+	}
+	value, set := lookup(name)
+	if rest == "" {
+		return value, nil
+	}
+
+	// The colon variants also treat an empty value as unset.
+	checkEmpty := strings.HasPrefix(rest, ":")
+	op := strings.TrimPrefix(rest, ":")
+	if op == "" {
+		return "", fmt.Errorf("invalid interpolation format for ${%s}", expr)
+	}
+	present := set && (!checkEmpty || value != "")
+	arg := op[1:]
+
+	switch op[0] {
+	case '-':
+		if present {
+			return value, nil
+		}
+		return interpolate(arg, lookup)
+	case '?':
+		if present {
+			return value, nil
+		}
+		msg, err := interpolate(arg, lookup)
+		if err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("required variable %s is missing a value: %s", name, msg)
+	case '+':
+		if present {
+			return interpolate(arg, lookup)
+		}
+		return "", nil
+	}
+	return "", fmt.Errorf("invalid interpolation format for ${%s}", expr)
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
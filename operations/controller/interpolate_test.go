@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestInterpolate(t *testing.T) {
+	env := map[string]string{"NAME": "web", "EMPTY": "", "PORT": "8080"}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	tests := []struct {
+		in      string
+		want    string
+		wantErr string
+	}{
+		{in: "plain", want: "plain"},
+		{in: "$NAME", want: "web"},
+		{in: "${NAME}", want: "web"},
+		{in: "${NAME}-1", want: "web-1"},
+		{in: "$NAME.local", want: "web.local"},
+		{in: "$UNSET", want: ""},
+		{in: "${UNSET}", want: ""},
+		{in: "$$", want: "$"},
+		{in: "$${NAME}", want: "${NAME}"},
+		{in: "cost: $5", want: "cost: $5"},
+		{in: "trailing $", want: "trailing $"},
+
+		{in: "${UNSET:-default}", want: "default"},
+		{in: "${EMPTY:-default}", want: "default"},
+		{in: "${EMPTY-default}", want: ""},
+		{in: "${UNSET-default}", want: "default"},
+		{in: "${NAME:-default}", want: "web"},
+		{in: "${UNSET:-${PORT}}", want: "8080"},
+		{in: "${UNSET:-${ALSO_UNSET:-deep}}", want: "deep"},
+
+		{in: "${NAME:?must be set}", want: "web"},
+		{in: "${EMPTY?must be set}", want: ""},
+		{in: "${UNSET:?must be set}", wantErr: "required variable UNSET is missing a value: must be set"},
+		{in: "${EMPTY:?must be set}", wantErr: "required variable EMPTY is missing a value: must be set"},
+		{in: "${UNSET?must be set}", wantErr: "required variable UNSET is missing a value: must be set"},
+
+		{in: "${NAME:+set}", want: "set"},
+		{in: "${EMPTY:+set}", want: ""},
+		{in: "${EMPTY+set}", want: "set"},
+		{in: "${UNSET+set}", want: ""},
+
+		{in: "${NAME", wantErr: "missing closing brace"},
+		{in: "${}", wantErr: "invalid interpolation format"},
+		{in: "${1ABC}", wantErr: "invalid interpolation format"},
+		{in: "${NAME:}", wantErr: "invalid interpolation format"},
+		{in: "${NAME!x}", wantErr: "invalid interpolation format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := interpolate(tt.in, lookup)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("interpolate(%q) error = %v, want error containing %q", tt.in, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("interpolate(%q) unexpected error: %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("interpolate(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterpolateNodeLeavesKeysAlone(t *testing.T) {
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte("$KEY: $VALUE\nlist: [$VALUE, plain]\n"), &root); err != nil {
+		t.Fatal(err)
+	}
+	lookup := func(name string) (string, bool) { return "x-" + name, true }
+	if err := interpolateNode(&root, lookup); err != nil {
+		t.Fatal(err)
+	}
+	out, err := yaml.Marshal(&root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "$KEY: x-VALUE\nlist: [x-VALUE, plain]\n"
+	if string(out) != want {
+		t.Errorf("interpolated document =\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestInterpolateNodeReportsLine(t *testing.T) {
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte("a: ok\nb: ${MISSING:?required}\n"), &root); err != nil {
+		t.Fatal(err)
+	}
+	err := interpolateNode(&root, func(string) (string, bool) { return "", false })
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2: ") {
+		t.Errorf("error = %v, want it to start with the line number", err)
+	}
+}
+
+func TestParseComposeFilesInterpolatesTypedFields(t *testing.T) {
+	t.Setenv("RETRIES", "3")
+	t.Setenv("PRIV", "true")
+	t.Setenv("EMPTY", "")
+	dir := t.TempDir()
+	file := filepath.Join(dir, "docker-compose.yml")
+	compose := `services:
+  web:
+    image: nginx
+    privileged: ${PRIV:-false}
+    read_only: ${READ_ONLY:-true}
+    tty: ${UNSET_TTY:-false}
+    healthcheck:
+      test: [CMD, "true"]
+      retries: ${RETRIES}
+    environment:
+      EMPTY: ${EMPTY}
+      QUOTED: "${RETRIES}"
+      NUMBER: ${RETRIES}
+`
+	if err := os.WriteFile(file, []byte(compose), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ParseComposeFiles([]string{file})
+	if err != nil {
+		t.Fatalf("ParseComposeFiles() error: %v", err)
+	}
+	web := config.Services["web"]
+	if web.HealthCheck == nil || web.HealthCheck.Retries != 3 {
+		t.Errorf("healthcheck = %+v, want retries 3", web.HealthCheck)
+	}
+	if !web.Privileged || !web.ReadOnly || web.Tty {
+		t.Errorf("privileged, read_only, tty = %v, %v, %v; want true, true, false", web.Privileged, web.ReadOnly, web.Tty)
+	}
+	wantEnv := []string{"EMPTY=", "QUOTED=3", "NUMBER=3"}
+	if !reflect.DeepEqual([]string(web.Environment), wantEnv) {
+		t.Errorf("environment = %q, want %q", web.Environment, wantEnv)
+	}
+}
+
+func TestParseComposeFilesRejectsMistypedVariable(t *testing.T) {
+	t.Setenv("RETRIES", "three")
+	dir := t.TempDir()
+	file := filepath.Join(dir, "docker-compose.yml")
+	compose := "services:\n  web:\n    image: nginx\n    healthcheck:\n      test: [CMD, \"true\"]\n      retries: ${RETRIES}\n"
+	if err := os.WriteFile(file, []byte(compose), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseComposeFiles([]string{file}); err == nil {
+		t.Error("ParseComposeFiles() succeeded, want an error for a non-numeric retries value")
+	}
+}
@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// logBuffer holds log records back so the logs of services reconciled concurrently can be
+// written one service after another, in a stable order.
+type logBuffer struct {
+	mu      sync.Mutex
+	records []bufferedRecord
+}
+
+type bufferedRecord struct {
+	handler slog.Handler
+	record  slog.Record
+}
+
+// logger returns a logger that writes into the buffer. Records keep the time they were logged
+// at and are formatted by base's handler when flushed.
+func (b *logBuffer) logger(base *slog.Logger) *slog.Logger {
+	return slog.New(&bufferHandler{buffer: b, target: base.Handler()})
+}
+
+// flush writes the buffered records in the order they were logged and empties the buffer.
+func (b *logBuffer) flush(ctx context.Context) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, r := range b.records {
+		_ = r.handler.Handle(ctx, r.record)
+	}
+	b.records = nil
+}
+
+type bufferHandler struct {
+	buffer *logBuffer
+	target slog.Handler
+}
+
+func (h *bufferHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.target.Enabled(ctx, level)
+}
+
+func (h *bufferHandler) Handle(_ context.Context, r slog.Record) error {
+	h.buffer.mu.Lock()
+	defer h.buffer.mu.Unlock()
+	h.buffer.records = append(h.buffer.records, bufferedRecord{handler: h.target, record: r.Clone()})
+	return nil
+}
+
+func (h *bufferHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &bufferHandler{buffer: h.buffer, target: h.target.WithAttrs(attrs)}
+}
+
+func (h *bufferHandler) WithGroup(name string) slog.Handler {
+	return &bufferHandler{buffer: h.buffer, target: h.target.WithGroup(name)}
+}
@@ -0,0 +1,210 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Merge behaviour of sequences, following the docker compose merge rules.
+var (
+	// overrideSequences are replaced by the overriding file instead of being appended to.
+	overrideSequences = map[string]bool{"command": true, "entrypoint": true, "test": true}
+	// keyedMappingSequences may be written as KEY=value lists or as maps and are merged by key.
+	keyedMappingSequences = map[string]string{"environment": "=", "labels": "=", "sysctls": "=", "extra_hosts": ":"}
+	// namedMappingSequences may be written as lists of names or as maps keyed by name.
+	namedMappingSequences = map[string]bool{"depends_on": true}
+	// targetSequences are merged by the container path they mount.
+	targetSequences = map[string]bool{"volumes": true, "devices": true}
+)
+
+// Tags that change how a value of an overriding file is merged, as in docker compose.
+const (
+	// resetTag removes the value from the merged configuration.
+	resetTag = "!reset"
+	// overrideTag replaces the value instead of merging it.
+	overrideTag = "!override"
+)
+
+// mergeComposeNodes merges override into base following docker compose semantics: mappings are
+// merged recursively, environment-like sequences and depends_on are merged by key, volumes and
+// devices by their target path, command, entrypoint and healthcheck tests are replaced, and other
+// sequences are appended without duplicates. Scalars from override win. Values tagged !reset
+// or !override are removed or replaced; stripMergeTags applies the tags left in the result.
+func mergeComposeNodes(base, override *yaml.Node) (*yaml.Node, error) {
+	return mergeNode("", resolveAlias(base), resolveAlias(override))
+}
+
+func mergeNode(key string, base, override *yaml.Node) (*yaml.Node, error) {
+	if override != nil && (override.Tag == resetTag || override.Tag == overrideTag) {
+		return override, nil
+	}
+	if base == nil || base.Tag == "!!null" || base.Tag == resetTag {
+		return override, nil
+	}
+	if override == nil || override.Tag == "!!null" {
+		return base, nil
+	}
+
+	if sep, ok := keyedMappingSequences[key]; ok {
+		base, override = sequenceToMapping(base, sep), sequenceToMapping(override, sep)
+	}
+	if namedMappingSequences[key] {
+		base, override = sequenceToMapping(base, ""), sequenceToMapping(override, "")
+	}
+
+	switch {
+	case base.Kind == yaml.MappingNode && override.Kind == yaml.MappingNode:
+		return mergeMappings(base, override)
+	case base.Kind == yaml.SequenceNode && override.Kind == yaml.SequenceNode:
+		switch {
+		case overrideSequences[key]:
+			return override, nil
+		case targetSequences[key]:
+			return mergeSequenceBy(base, override, mountTarget), nil
+		default:
+			return mergeSequenceBy(base, override, nodeIdentity), nil
+		}
+	case base.Kind != override.Kind && base.Kind != yaml.ScalarNode && override.Kind != yaml.ScalarNode:
+		return nil, fmt.Errorf("line %d: cannot merge '%s' of different types", override.Line, key)
+	}
+	return override, nil
+}
+
+func mergeMappings(base, override *yaml.Node) (*yaml.Node, error) {
+	merged := &yaml.Node{Kind: yaml.MappingNode, Tag: base.Tag, Line: base.Line, Column: base.Column}
+	merged.Content = append(merged.Content, base.Content...)
+
+	for i := 0; i+1 < len(override.Content); i += 2 {
+		k, v := override.Content[i], resolveAlias(override.Content[i+1])
+		found := false
+		for j := 0; j+1 < len(merged.Content); j += 2 {
+			if merged.Content[j].Value != k.Value {
+				continue
+			}
+			value, err := mergeNode(k.Value, resolveAlias(merged.Content[j+1]), v)
+			if err != nil {
+				return nil, err
+			}
+			merged.Content[j+1] = value
+			found = true
+			break
+		}
+		if !found {
+			merged.Content = append(merged.Content, k, v)
+		}
+	}
+	return merged, nil
+}
+
+// mergeSequenceBy appends the entries of override to base, replacing base entries with the same key.
+func mergeSequenceBy(base, override *yaml.Node, keyOf func(*yaml.Node) string) *yaml.Node {
+	merged := &yaml.Node{Kind: yaml.SequenceNode, Tag: base.Tag, Line: base.Line, Column: base.Column}
+	index := make(map[string]int)
+	add := func(item *yaml.Node) {
+		item = resolveAlias(item)
+		k := keyOf(item)
+		if i, ok := index[k]; ok {
+			merged.Content[i] = item
+			return
+		}
+		index[k] = len(merged.Content)
+		merged.Content = append(merged.Content, item)
+	}
+	for _, item := range base.Content {
+		add(item)
+	}
+	for _, item := range override.Content {
+		add(item)
+	}
+	return merged
+}
+
+// sequenceToMapping converts a KEY<sep>value list into the equivalent mapping. Entries without
+// a separator map to null, like "KEY" in an environment list. An empty sep maps every entry to null.
+func sequenceToMapping(node *yaml.Node, sep string) *yaml.Node {
+	if node.Kind != yaml.SequenceNode {
+		return node
+	}
+	mapping := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: node.Line, Column: node.Column}
+	for _, item := range node.Content {
+		item = resolveAlias(item)
+		k, v, hasValue := item.Value, "", false
+		if sep != "" {
+			k, v, hasValue = strings.Cut(item.Value, sep)
+		}
+		valueNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v, Line: item.Line, Column: item.Column}
+		if !hasValue {
+			valueNode.Tag, valueNode.Value = "!!null", ""
+		}
+		mapping.Content = append(mapping.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k, Line: item.Line, Column: item.Column},
+			valueNode)
+	}
+	return mapping
+}
+
+// mountTarget returns the container path of a volume or device entry in short or long syntax.
+func mountTarget(node *yaml.Node) string {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == "target" {
+				return node.Content[i+1].Value
+			}
+		}
+		return nodeIdentity(node)
+	}
+	parts := strings.Split(node.Value, ":")
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return parts[1]
+}
+
+// nodeIdentity returns a string that is equal for structurally equal nodes.
+func nodeIdentity(node *yaml.Node) string {
+	if node.Kind == yaml.ScalarNode {
+		return node.Value
+	}
+	out, err := yaml.Marshal(node)
+	if err != nil {
+		return fmt.Sprintf("%p", node)
+	}
+	return string(out)
+}
+
+// stripMergeTags removes the mapping entries tagged !reset from a merged document and drops
+// the !override tags, so the document decodes like one without them.
+func stripMergeTags(node *yaml.Node) {
+	node = resolveAlias(node)
+	if node == nil {
+		return
+	}
+	if node.Tag == overrideTag {
+		node.Tag = ""
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		content := node.Content[:0]
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if resolveAlias(node.Content[i+1]).Tag == resetTag {
+				continue
+			}
+			stripMergeTags(node.Content[i+1])
+			content = append(content, node.Content[i], node.Content[i+1])
+		}
+		node.Content = content
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			stripMergeTags(item)
+		}
+	}
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
@@ -0,0 +1,173 @@
+package controller
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestMergeComposeNodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     string
+		override string
+		want     string
+	}{
+		{
+			name:     "scalars from override win",
+			base:     "services: {web: {image: nginx:1.25, container_name: web}}",
+			override: "services: {web: {image: nginx:1.27}}",
+			want:     "services: {web: {image: nginx:1.27, container_name: web}}",
+		},
+		{
+			name:     "new services and keys are added",
+			base:     "services: {web: {image: nginx}}",
+			override: "services: {web: {restart: always}, db: {image: postgres}}",
+			want:     "services: {web: {image: nginx, restart: always}, db: {image: postgres}}",
+		},
+		{
+			name:     "environment is merged by key across list and map forms",
+			base:     "services: {web: {environment: [A=1, B=2, C]}}",
+			override: "services: {web: {environment: {B: 3, D: 4}}}",
+			want:     "services: {web: {environment: {A: '1', B: 3, C: null, D: 4}}}",
+		},
+		{
+			name:     "labels are merged by key",
+			base:     "services: {web: {labels: [a=1, b=2]}}",
+			override: "services: {web: {labels: [b=3]}}",
+			want:     "services: {web: {labels: {a: '1', b: '3'}}}",
+		},
+		{
+			name:     "extra_hosts are merged by host",
+			base:     "services: {web: {extra_hosts: ['db:10.0.0.1', 'cache:10.0.0.2']}}",
+			override: "services: {web: {extra_hosts: ['db:10.0.0.9']}}",
+			want:     "services: {web: {extra_hosts: {db: 10.0.0.9, cache: 10.0.0.2}}}",
+		},
+		{
+			name:     "depends_on is merged by service",
+			base:     "services: {web: {depends_on: [db]}}",
+			override: "services: {web: {depends_on: {db: {condition: service_healthy}, cache: {}}}}",
+			want:     "services: {web: {depends_on: {db: {condition: service_healthy}, cache: {}}}}",
+		},
+		{
+			name:     "volumes are merged by target",
+			base:     "services: {web: {volumes: ['data:/data', './conf:/etc/conf:ro']}}",
+			override: "services: {web: {volumes: ['other:/data', {type: tmpfs, target: /tmp}]}}",
+			want:     "services: {web: {volumes: ['other:/data', './conf:/etc/conf:ro', {type: tmpfs, target: /tmp}]}}",
+		},
+		{
+			name:     "long syntax volumes replace short syntax with the same target",
+			base:     "services: {web: {volumes: ['data:/data']}}",
+			override: "services: {web: {volumes: [{type: volume, source: other, target: /data}]}}",
+			want:     "services: {web: {volumes: [{type: volume, source: other, target: /data}]}}",
+		},
+		{
+			name:     "command, entrypoint and healthcheck test are replaced",
+			base:     "services: {web: {command: [a, b], entrypoint: [x], healthcheck: {test: [CMD, a], interval: 5s}}}",
+			override: "services: {web: {command: [c], entrypoint: [y, z], healthcheck: {test: [CMD, b]}}}",
+			want:     "services: {web: {command: [c], entrypoint: [y, z], healthcheck: {test: [CMD, b], interval: 5s}}}",
+		},
+		{
+			name:     "a string command replaces a list",
+			base:     "services: {web: {command: [a, b]}}",
+			override: "services: {web: {command: npm start}}",
+			want:     "services: {web: {command: npm start}}",
+		},
+		{
+			name:     "other sequences are appended without duplicates",
+			base:     "services: {web: {ports: ['80:80', '443:443'], networks: [front]}}",
+			override: "services: {web: {ports: ['443:443', '8080:8080'], networks: [back]}}",
+			want:     "services: {web: {ports: ['80:80', '443:443', '8080:8080'], networks: [front, back]}}",
+		},
+		{
+			name:     "null in override keeps the base value",
+			base:     "services: {web: {image: nginx}}",
+			override: "services: {web: {image: null}}",
+			want:     "services: {web: {image: nginx}}",
+		},
+		{
+			name:     "reset removes a key",
+			base:     "services: {web: {image: nginx, ports: ['80:80'], environment: {A: '1'}}}",
+			override: "services: {web: {ports: !reset [], environment: !reset {}}}",
+			want:     "services: {web: {image: nginx}}",
+		},
+		{
+			name:     "reset removes a service",
+			base:     "services: {web: {image: nginx}, debug: {image: busybox}}",
+			override: "services: {debug: !reset null}",
+			want:     "services: {web: {image: nginx}}",
+		},
+		{
+			name:     "a key reset earlier can be set again",
+			base:     "services: {web: {ports: !reset []}}",
+			override: "services: {web: {ports: ['8080:80']}}",
+			want:     "services: {web: {ports: ['8080:80']}}",
+		},
+		{
+			name:     "override replaces instead of merging",
+			base:     "services: {web: {ports: ['80:80'], environment: {A: '1', B: '2'}}}",
+			override: "services: {web: {ports: !override ['8080:80'], environment: !override {C: '3'}}}",
+			want:     "services: {web: {ports: ['8080:80'], environment: {C: '3'}}}",
+		},
+		{
+			name:     "anchors and aliases are resolved",
+			base:     "x-env: &env {A: '1'}\nservices: {web: {environment: *env}}",
+			override: "services: {web: {environment: {B: '2'}}}",
+			want:     "x-env: {A: '1'}\nservices: {web: {environment: {A: '1', B: '2'}}}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := mergeComposeNodes(parseNode(t, tt.base), parseNode(t, tt.override))
+			if err != nil {
+				t.Fatalf("mergeComposeNodes() error: %v", err)
+			}
+			stripMergeTags(merged)
+			var got, want any
+			if err := merged.Decode(&got); err != nil {
+				t.Fatalf("decoding merged document: %v", err)
+			}
+			if err := parseNode(t, tt.want).Decode(&want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				out, _ := yaml.Marshal(got)
+				t.Errorf("merged document =\n%s\nwant\n%s", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeComposeNodesRejectsMismatchedTypes(t *testing.T) {
+	_, err := mergeComposeNodes(
+		parseNode(t, "services: {web: {networks: [front]}}"),
+		parseNode(t, "services: {web: {networks: {front: {}}}}"))
+	if err == nil || !strings.Contains(err.Error(), "cannot merge 'networks' of different types") {
+		t.Errorf("error = %v, want a type mismatch error", err)
+	}
+}
+
+func TestStripMergeTagsInSingleFile(t *testing.T) {
+	node := parseNode(t, "services: {web: {image: nginx, ports: !reset [], command: !override [a]}}")
+	stripMergeTags(node)
+	var compose Compose
+	if err := node.Decode(&compose); err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	web := compose.Services["web"]
+	if len(web.Ports) != 0 || !reflect.DeepEqual([]string(web.Command), []string{"a"}) {
+		t.Errorf("web = ports %v, command %v; want no ports and command [a]", web.Ports, web.Command)
+	}
+}
+
+// parseNode returns the top-level mapping of a YAML document.
+func parseNode(t *testing.T, doc string) *yaml.Node {
+	t.Helper()
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(doc), &root); err != nil {
+		t.Fatalf("parsing %q: %v", doc, err)
+	}
+	return root.Content[0]
+}
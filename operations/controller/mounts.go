@@ -0,0 +1,224 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/moby/moby/api/types/mount"
+	"gopkg.in/yaml.v3"
+)
+
+// Volume mount types of the long volume syntax.
+const (
+	MountBind   = "bind"
+	MountVolume = "volume"
+	MountTmpfs  = "tmpfs"
+)
+
+// Ports holds a service's published ports in Docker's short syntax
+// ("[host_ip:][published:]target[/protocol]"). Long syntax entries are converted on parsing.
+type Ports []string
+
+// portConfig is a port in the long syntax.
+type portConfig struct {
+	Target    string `yaml:"target"`
+	Published string `yaml:"published"`
+	HostIP    string `yaml:"host_ip"`
+	Protocol  string `yaml:"protocol"`
+	Mode      string `yaml:"mode"`
+}
+
+func (Ports) yamlSchema() any { return []portConfig{} }
+
+func (p *Ports) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.SequenceNode {
+		return fmt.Errorf("line %d: ports must be a list", value.Line)
+	}
+	ports := make(Ports, 0, len(value.Content))
+	for _, item := range value.Content {
+		if item.Kind == yaml.ScalarNode {
+			ports = append(ports, item.Value)
+			continue
+		}
+		var long portConfig
+		if err := item.Decode(&long); err != nil {
+			return err
+		}
+		if long.Target == "" {
+			return fmt.Errorf("line %d: port is missing a target", item.Line)
+		}
+		spec := long.Target
+		if long.Published != "" || long.HostIP != "" {
+			spec = long.Published + ":" + spec
+		}
+		if long.HostIP != "" {
+			host := long.HostIP
+			if strings.Contains(host, ":") {
+				host = "[" + host + "]"
+			}
+			spec = host + ":" + spec
+		}
+		if long.Protocol != "" {
+			spec += "/" + long.Protocol
+		}
+		ports = append(ports, spec)
+	}
+	*p = ports
+	return nil
+}
+
+// ServiceVolume is one entry of a service's volumes. Entries in short syntax
+// ("source:target[:mode]") are kept in Short and passed to Docker as binds; entries in long
+// syntax are passed as mounts.
+type ServiceVolume struct {
+	Short    string         `yaml:"-"`
+	Type     string         `yaml:"type"`
+	Source   string         `yaml:"source"`
+	Target   string         `yaml:"target"`
+	ReadOnly bool           `yaml:"read_only"`
+	Bind     *BindOptions   `yaml:"bind"`
+	Volume   *VolumeOptions `yaml:"volume"`
+	Tmpfs    *TmpfsOptions  `yaml:"tmpfs"`
+}
+
+type BindOptions struct {
+	Propagation    string `yaml:"propagation"`
+	CreateHostPath bool   `yaml:"create_host_path"`
+}
+
+type VolumeOptions struct {
+	NoCopy  bool   `yaml:"nocopy"`
+	Subpath string `yaml:"subpath"`
+}
+
+type TmpfsOptions struct {
+	// Size is a byte count or a size with a unit, e.g. "64m".
+	Size string `yaml:"size"`
+	// Mode holds the permission bits in octal, e.g. "1777".
+	Mode string `yaml:"mode"`
+}
+
+func (v *ServiceVolume) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*v = ServiceVolume{Short: value.Value}
+		return nil
+	}
+	type plain ServiceVolume
+	var long plain
+	if err := value.Decode(&long); err != nil {
+		return err
+	}
+	*v = ServiceVolume(long)
+	switch v.Type {
+	case MountBind, MountVolume, MountTmpfs:
+	case "":
+		return fmt.Errorf("line %d: volume is missing a type", value.Line)
+	default:
+		return fmt.Errorf("line %d: unsupported volume type '%s'", value.Line, v.Type)
+	}
+	if v.Target == "" {
+		return fmt.Errorf("line %d: volume is missing a target", value.Line)
+	}
+	if v.Type == MountBind && v.Source == "" {
+		return fmt.Errorf("line %d: bind mount is missing a source", value.Line)
+	}
+	return nil
+}
+
+// resolveVolumePaths makes relative bind mount sources absolute, relative to baseDir,
+// as Docker only accepts absolute host paths.
+func resolveVolumePaths(service *Service, baseDir string) {
+	for i, v := range service.Volumes {
+		if v.Short != "" {
+			source, rest, ok := strings.Cut(v.Short, ":")
+			if ok && isRelativePath(source) {
+				service.Volumes[i].Short = resolveHostPath(source, baseDir) + ":" + rest
+			}
+			continue
+		}
+		if v.Type == MountBind && isRelativePath(v.Source) {
+			service.Volumes[i].Source = resolveHostPath(v.Source, baseDir)
+		}
+	}
+}
+
+func isRelativePath(source string) bool {
+	return source == "." || source == ".." || source == "~" ||
+		strings.HasPrefix(source, "./") || strings.HasPrefix(source, "../") || strings.HasPrefix(source, "~/")
+}
+
+func resolveHostPath(source, baseDir string) string {
+	if source == "~" || strings.HasPrefix(source, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, strings.TrimPrefix(source, "~"))
+		}
+	}
+	if abs, err := filepath.Abs(filepath.Join(baseDir, source)); err == nil {
+		return abs
+	}
+	return filepath.Join(baseDir, source)
+}
+
+// volumeMounts translates a service's volumes into Docker binds and mounts. Named volumes are
+// prefixed with the project name.
+func volumeMounts(projectName string, volumes []ServiceVolume) ([]string, []mount.Mount, error) {
+	var binds []string
+	var mounts []mount.Mount
+	for _, v := range volumes {
+		if v.Short != "" {
+			source, rest, ok := strings.Cut(v.Short, ":")
+			if !ok {
+				// A lone container path is an anonymous volume.
+				mounts = append(mounts, mount.Mount{Type: mount.TypeVolume, Target: source})
+				continue
+			}
+			// Named volumes, unlike host paths, are prefixed with the project name.
+			if !strings.HasPrefix(source, "/") && !strings.HasPrefix(source, ".") {
+				source = fmt.Sprintf("%s_%s", projectName, source)
+			}
+			binds = append(binds, source+":"+rest)
+			continue
+		}
+
+		m := mount.Mount{Type: mount.Type(v.Type), Source: v.Source, Target: v.Target, ReadOnly: v.ReadOnly}
+		switch v.Type {
+		case MountBind:
+			if v.Bind != nil {
+				m.BindOptions = &mount.BindOptions{Propagation: mount.Propagation(v.Bind.Propagation), CreateMountpoint: v.Bind.CreateHostPath}
+			}
+		case MountVolume:
+			if v.Source != "" {
+				m.Source = fmt.Sprintf("%s_%s", projectName, v.Source)
+			}
+			if v.Volume != nil {
+				m.VolumeOptions = &mount.VolumeOptions{NoCopy: v.Volume.NoCopy, Subpath: v.Volume.Subpath}
+			}
+		case MountTmpfs:
+			m.Source = ""
+			if v.Tmpfs != nil {
+				opts := &mount.TmpfsOptions{}
+				if v.Tmpfs.Size != "" {
+					size, err := units.RAMInBytes(v.Tmpfs.Size)
+					if err != nil {
+						return nil, nil, fmt.Errorf("invalid tmpfs size '%s' for %s: %w", v.Tmpfs.Size, v.Target, err)
+					}
+					opts.SizeBytes = size
+				}
+				if v.Tmpfs.Mode != "" {
+					mode, err := strconv.ParseUint(v.Tmpfs.Mode, 8, 32)
+					if err != nil {
+						return nil, nil, fmt.Errorf("invalid tmpfs mode '%s' for %s: %w", v.Tmpfs.Mode, v.Target, err)
+					}
+					opts.Mode = os.FileMode(mode)
+				}
+				m.TmpfsOptions = opts
+			}
+		}
+		mounts = append(mounts, m)
+	}
+	return binds, mounts, nil
+}
@@ -4,13 +4,15 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
 
 	"github.com/moby/moby/api/types/filters"
 	"github.com/moby/moby/api/types/network"
 	"github.com/moby/moby/client"
 )
 
-func ReconcileNetworks(ctx context.Context, cli *client.Client, projectName string, networks map[string]Network, logger *slog.Logger) {
+func ReconcileNetworks(ctx context.Context, cli *client.Client, projectName string, networks map[string]Network, plan *Plan, logger *slog.Logger) {
 	logger.Info("Reconciling networks...")
 
 	netFilters := filters.NewArgs(filters.Arg("label", "com.docker.compose.project="+projectName))
@@ -35,7 +37,8 @@ func ReconcileNetworks(ctx context.Context, cli *client.Client, projectName stri
 		actualNetworksMap[net.Labels["com.docker.compose.network"]] = struct{}{}
 	}
 
-	for networkName, net := range networks {
+	for _, networkName := range slices.Sorted(maps.Keys(networks)) {
+		net := networks[networkName]
 		if net.External {
 			logger.Info("Skipping creation for external network", "network_name", networkName)
 			continue
@@ -43,8 +46,13 @@ func ReconcileNetworks(ctx context.Context, cli *client.Client, projectName stri
 		if _, exists := actualNetworksMap[networkName]; exists {
 			continue
 		}
-		logger.Info("Creating network...", "network_name", networkName)
 		fullNetworkName := fmt.Sprintf("%s_%s", projectName, networkName)
+		plan.add(KindNetwork, networkName, ActionCreate, "not found")
+		if plan.DryRun {
+			logger.Info("Dry run: would create network", "full_network_name", fullNetworkName)
+			continue
+		}
+		logger.Info("Creating network...", "network_name", networkName)
 		_, err := cli.NetworkCreate(ctx, fullNetworkName, network.CreateOptions{
 			Driver: net.Driver,
 			Labels: map[string]string{
@@ -62,6 +70,11 @@ func ReconcileNetworks(ctx context.Context, cli *client.Client, projectName stri
 	for _, actualNet := range actualNetworks {
 		networkName := actualNet.Labels["com.docker.compose.network"]
 		if _, existsInDesired := networks[networkName]; !existsInDesired {
+			plan.add(KindNetwork, networkName, ActionRemove, "orphaned")
+			if plan.DryRun {
+				logger.Info("Dry run: would remove orphaned network", "network_name", actualNet.Name)
+				continue
+			}
 			logger.Info("Found orphaned network. Removing...", "network_name", actualNet.Name)
 			if err := cli.NetworkRemove(ctx, actualNet.ID); err != nil {
 				logger.Error("Failed to remove the network", "network_name", actualNet.Name, "error", err)
@@ -3,22 +3,122 @@ package controller
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+// ParseComposeFile reads a single compose file. See ParseComposeFiles.
 func ParseComposeFile(filePath string) (*Compose, error) {
-	yamlFile, err := os.ReadFile(filePath)
+	return ParseComposeFiles([]string{filePath})
+}
+
+// ParseComposeFiles reads an ordered list of compose files and merges each one into the
+// previous ones with docker compose's merge rules. ${VAR} references are interpolated using
+// Watcher's environment and the .env file next to the first file, and relative env_file
+// entries and bind mount sources are resolved against the first file's directory. Keys
+// Watcher does not support are rejected.
+func ParseComposeFiles(filePaths []string) (*Compose, error) {
+	if len(filePaths) == 0 {
+		return nil, fmt.Errorf("no compose file specified")
+	}
 
+	baseDir := filepath.Dir(filePaths[0])
+	lookup, err := interpolationLookup(baseDir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read compose file %s: %w", filePath, err)
+		return nil, err
+	}
+
+	var merged *yaml.Node
+	for _, filePath := range filePaths {
+		root, err := readComposeNode(filePath, lookup)
+		if err != nil {
+			return nil, err
+		}
+		if root == nil {
+			continue
+		}
+		if merged == nil {
+			merged = root
+			continue
+		}
+		if merged, err = mergeComposeNodes(merged, root); err != nil {
+			return nil, fmt.Errorf("failed to merge compose file %s: %w", filePath, err)
+		}
 	}
 
 	var composeConfig Compose
+	if merged != nil {
+		stripMergeTags(merged)
+		if err := checkKeys(merged, reflect.TypeFor[Compose](), ""); err != nil {
+			return nil, fmt.Errorf("invalid compose file: %w", err)
+		}
+		if err := merged.Decode(&composeConfig); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal compose file: %w", err)
+		}
+	}
 
-	err = yaml.Unmarshal(yamlFile, &composeConfig)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal compose file: %w", err)
+	for name, service := range composeConfig.Services {
+		if err := resolveEnvironment(&service, baseDir); err != nil {
+			return nil, fmt.Errorf("service '%s': %w", name, err)
+		}
+		resolveVolumePaths(&service, baseDir)
+		if strategy := service.updateStrategy(); strategy != UpdateStopFirst && strategy != UpdateStartFirst {
+			return nil, fmt.Errorf("service '%s': unknown x-watcher update_strategy '%s'", name, strategy)
+		}
+		if service.XWatcher != nil && service.XWatcher.HealthTimeout != "" {
+			if _, err := time.ParseDuration(service.XWatcher.HealthTimeout); err != nil {
+				return nil, fmt.Errorf("service '%s': invalid x-watcher health_timeout '%s': %w", name, service.XWatcher.HealthTimeout, err)
+			}
+		}
+		if service.XWatcher != nil && service.XWatcher.CompletionTimeout != "" {
+			if _, err := time.ParseDuration(service.XWatcher.CompletionTimeout); err != nil {
+				return nil, fmt.Errorf("service '%s': invalid x-watcher completion_timeout '%s': %w", name, service.XWatcher.CompletionTimeout, err)
+			}
+		}
+		if err := ValidatePullPolicy(service.PullPolicy); err != nil {
+			return nil, fmt.Errorf("service '%s': %w", name, err)
+		}
+		if err := service.DependsOn.validate(); err != nil {
+			return nil, fmt.Errorf("service '%s': %w", name, err)
+		}
+		// Building the container configuration checks ports, mounts, healthcheck durations,
+		// resources and runtime options, so invalid values are reported before anything is applied.
+		if _, err := buildContainerSpec("", name, &service); err != nil {
+			return nil, fmt.Errorf("service '%s': %w", name, err)
+		}
+		if err := resolveServiceNamespaces(&composeConfig, name, &service); err != nil {
+			return nil, fmt.Errorf("service '%s': %w", name, err)
+		}
+		composeConfig.Services[name] = service
 	}
 	return &composeConfig, nil
 }
+
+// readComposeNode reads and interpolates one compose file and returns its top-level mapping,
+// or nil for an empty file.
+func readComposeNode(filePath string, lookup lookupFunc) (*yaml.Node, error) {
+	yamlFile, err := os.ReadFile(filePath)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compose file %s: %w", filePath, err)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(yamlFile, &root); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal compose file %s: %w", filePath, err)
+	}
+	if len(root.Content) == 0 {
+		return nil, nil
+	}
+	if err := interpolateNode(&root, lookup); err != nil {
+		return nil, fmt.Errorf("failed to interpolate compose file %s: %w", filePath, err)
+	}
+	doc := root.Content[0]
+	if doc.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("compose file %s: top level must be a mapping", filePath)
+	}
+	return doc, nil
+}
@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sithukyaw666/watcher/metrics"
+)
+
+// ActionType describes what the reconciler does, or would do in dry-run mode, to a resource.
+type ActionType string
+
+const (
+	ActionCreate   ActionType = "create"
+	ActionRecreate ActionType = "recreate"
+	ActionStart    ActionType = "start"
+	ActionRestart  ActionType = "restart"
+	ActionRemove   ActionType = "remove"
+)
+
+// ResourceKind is the kind of Docker object an action applies to.
+type ResourceKind string
+
+const (
+	KindService ResourceKind = "service"
+	KindNetwork ResourceKind = "network"
+	KindVolume  ResourceKind = "volume"
+	KindImage   ResourceKind = "image"
+)
+
+// Action is a single reconciliation step.
+type Action struct {
+	Kind   ResourceKind `json:"kind"`
+	Name   string       `json:"name"`
+	Type   ActionType   `json:"action"`
+	Reason string       `json:"reason,omitempty"`
+}
+
+// ApplyOptions controls how Apply reconciles a project.
+type ApplyOptions struct {
+	// DryRun computes the plan against the live Docker state without changing anything.
+	DryRun bool
+	// RegistryAuth provides credentials for pulling images from private registries.
+	RegistryAuth *RegistryAuth
+	// PullPolicy is used for services without a pull_policy. It defaults to PullAlways.
+	PullPolicy string
+	// PullTimes records image pulls for the time based pull policies.
+	PullTimes *PullTimes
+	// HealthTimeout bounds every wait for a container to become healthy. When zero the wait is
+	// derived from the service's healthcheck. A service's x-watcher health_timeout takes precedence.
+	HealthTimeout time.Duration
+	// AbortOnDependencyFailure skips every service that depends, directly or not, on a service
+	// that failed, instead of reconciling it anyway.
+	AbortOnDependencyFailure bool
+	// Parallelism is the maximum number of services reconciled at the same time. Values below
+	// one reconcile a single service at a time.
+	Parallelism int
+	// Services restricts reconciliation to the named services. Volumes, networks and orphaned
+	// containers are left alone, images are not pulled and unhealthy containers are restarted.
+	Services []string
+}
+
+// Plan is the ordered list of actions taken, or intended in dry-run mode, during a reconciliation.
+type Plan struct {
+	Project string   `json:"project"`
+	DryRun  bool     `json:"dry_run"`
+	Actions []Action `json:"actions"`
+}
+
+func (p *Plan) add(kind ResourceKind, name string, actionType ActionType, reason string) {
+	p.Actions = append(p.Actions, Action{Kind: kind, Name: name, Type: actionType, Reason: reason})
+	if kind == KindService && !p.DryRun {
+		metrics.ServiceActions.WithLabelValues(p.Project, name, string(actionType)).Inc()
+	}
+}
+
+// changed reports whether the plan (re)creates, starts or restarts the named service.
+func (p *Plan) changed(service string) bool {
+	for _, a := range p.Actions {
+		if a.Kind == KindService && a.Name == service && a.Type != ActionRemove {
+			return true
+		}
+	}
+	return false
+}
+
+// ChangesContainers reports whether the plan creates, re-creates or removes a service's
+// container, the only actions that can leave an image unused.
+func (p *Plan) ChangesContainers() bool {
+	for _, a := range p.Actions {
+		if a.Kind == KindService && (a.Type == ActionCreate || a.Type == ActionRecreate || a.Type == ActionRemove) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteText writes a human readable summary of the plan.
+func (p *Plan) WriteText(w io.Writer) error {
+	mode := "applied"
+	if p.DryRun {
+		mode = "dry run"
+	}
+	if _, err := fmt.Fprintf(w, "Plan for project %q (%s):\n", p.Project, mode); err != nil {
+		return err
+	}
+	if len(p.Actions) == 0 {
+		_, err := fmt.Fprintln(w, "  No changes. Docker state matches the compose file.")
+		return err
+	}
+	for _, a := range p.Actions {
+		line := fmt.Sprintf("  %s %-7s %-24s %s", actionSymbol(a.Type), a.Kind, a.Name, a.Type)
+		if a.Reason != "" {
+			line += fmt.Sprintf(" (%s)", a.Reason)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d action(s).\n", len(p.Actions))
+	return err
+}
+
+func actionSymbol(t ActionType) string {
+	switch t {
+	case ActionCreate:
+		return "+"
+	case ActionRecreate:
+		return "~"
+	case ActionStart:
+		return ">"
+	case ActionRestart:
+		return "*"
+	case ActionRemove:
+		return "-"
+	}
+	return " "
+}
@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/errdefs"
+	"github.com/moby/moby/client"
+)
+
+// Pull policies, as in compose's pull_policy. Besides these, "every_<duration>" (e.g.
+// "every_12h") pulls when the image was last pulled longer ago than the duration.
+const (
+	// PullAlways pulls the image on every reconciliation.
+	PullAlways = "always"
+	// PullMissing only pulls images that are not present locally.
+	PullMissing = "missing"
+	// PullNever never pulls, for locally built or loaded images.
+	PullNever = "never"
+	// PullDaily pulls when the image was last pulled more than a day ago.
+	PullDaily = "daily"
+	// PullWeekly pulls when the image was last pulled more than a week ago.
+	PullWeekly = "weekly"
+)
+
+const pullEveryPrefix = "every_"
+
+// ValidatePullPolicy checks that policy is a supported pull policy. An empty policy is valid.
+func ValidatePullPolicy(policy string) error {
+	_, err := pullInterval(policy)
+	return err
+}
+
+// pullInterval returns the maximum age of a pull for the time based policies, and zero for
+// the others.
+func pullInterval(policy string) (time.Duration, error) {
+	switch policy {
+	case "", PullAlways, PullMissing, PullNever:
+		return 0, nil
+	case PullDaily:
+		return 24 * time.Hour, nil
+	case PullWeekly:
+		return 7 * 24 * time.Hour, nil
+	}
+	if rest, ok := strings.CutPrefix(policy, pullEveryPrefix); ok {
+		d, err := time.ParseDuration(rest)
+		if err == nil && d > 0 {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported pull policy '%s'", policy)
+}
+
+// PullTimes remembers when images were last pulled, for the time based pull policies.
+// It is safe for concurrent use, and a nil PullTimes remembers nothing.
+type PullTimes struct {
+	mu    sync.Mutex
+	times map[string]time.Time
+}
+
+// NewPullTimes returns a PullTimes starting from previously recorded pull times.
+func NewPullTimes(times map[string]time.Time) *PullTimes {
+	p := &PullTimes{times: make(map[string]time.Time, len(times))}
+	maps.Copy(p.times, times)
+	return p
+}
+
+// Times returns a copy of the recorded pull times, keyed by image reference.
+func (p *PullTimes) Times() map[string]time.Time {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return maps.Clone(p.times)
+}
+
+func (p *PullTimes) last(imageRef string) (time.Time, bool) {
+	if p == nil {
+		return time.Time{}, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.times[imageRef]
+	return t, ok
+}
+
+func (p *PullTimes) record(imageRef string, t time.Time) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.times[imageRef] = t
+}
+
+// pullPolicy returns the service's pull policy, falling back to opts.PullPolicy and then to
+// PullAlways, so services following a moving tag keep picking up new images.
+func pullPolicy(service *Service, opts ApplyOptions) string {
+	if service.PullPolicy != "" {
+		return service.PullPolicy
+	}
+	if opts.PullPolicy != "" {
+		return opts.PullPolicy
+	}
+	return PullAlways
+}
+
+// ensureImage makes the service's image available locally, pulling it when its pull policy
+// asks for it. When a pull fails but the image is present locally, the local image is used,
+// so a registry outage does not hold up reconciliation.
+func ensureImage(ctx context.Context, cli *client.Client, service *Service, opts ApplyOptions, logger *slog.Logger) error {
+	policy := pullPolicy(service, opts)
+	_, err := cli.ImageInspect(ctx, service.Image)
+	present := err == nil
+	if err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("failed to inspect image %s: %w", service.Image, err)
+	}
+
+	pull := false
+	switch policy {
+	case PullAlways:
+		pull = true
+	case PullNever:
+		if !present {
+			return fmt.Errorf("image %s is not present locally and its pull policy is '%s'", service.Image, PullNever)
+		}
+	case PullMissing:
+		pull = !present
+	default:
+		interval, err := pullInterval(policy)
+		if err != nil {
+			return err
+		}
+		last, ok := opts.PullTimes.last(service.Image)
+		pull = !present || !ok || time.Since(last) >= interval
+	}
+	if !pull {
+		logger.Info("Using local image", "image", service.Image, "pull_policy", policy)
+		return nil
+	}
+
+	if err := pullImage(ctx, cli, service.Image, opts.RegistryAuth, logger); err != nil {
+		if present {
+			logger.Warn("Could not pull image, using the local copy.", "image", service.Image, "error", err)
+			return nil
+		}
+		return fmt.Errorf("failed to pull image %s: %w", service.Image, err)
+	}
+	opts.PullTimes.record(service.Image, time.Now())
+	logger.Info("Image pulled successfully.", "image", service.Image, "pull_policy", policy)
+	return nil
+}
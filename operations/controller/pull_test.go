@@ -0,0 +1,25 @@
+package controller
+
+import "testing"
+
+func TestPullPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		service string
+		opts    string
+		want    string
+	}{
+		{name: "default", want: PullAlways},
+		{name: "project default", opts: PullMissing, want: PullMissing},
+		{name: "service overrides project", service: PullNever, opts: PullMissing, want: PullNever},
+		{name: "service without project default", service: "daily", want: "daily"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := pullPolicy(&Service{PullPolicy: tt.service}, ApplyOptions{PullPolicy: tt.opts})
+			if got != tt.want {
+				t.Errorf("pullPolicy() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/distribution/reference"
+	"github.com/moby/moby/api/types/registry"
+)
+
+// dockerHubAuthKey is the key docker uses for Docker Hub in config.json.
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+// RegistryCredential is a set of credentials for one registry, as configured in config.yaml.
+type RegistryCredential struct {
+	Server   string
+	Username string
+	Password string
+}
+
+// dockerConfigFile is the subset of a Docker CLI config.json used for registry authentication.
+type dockerConfigFile struct {
+	Auths       map[string]dockerAuthEntry `json:"auths"`
+	CredsStore  string                     `json:"credsStore"`
+	CredHelpers map[string]string          `json:"credHelpers"`
+}
+
+type dockerAuthEntry struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+// RegistryAuth resolves the credentials used to pull an image. Explicitly configured
+// credentials take precedence over a Docker config.json.
+type RegistryAuth struct {
+	configured   map[string]RegistryCredential
+	dockerConfig *dockerConfigFile
+	logger       *slog.Logger
+
+	mu sync.Mutex
+	// failedHelpers holds the credential helpers that failed, so they are only tried and
+	// reported once.
+	failedHelpers map[string]bool
+}
+
+// LoadRegistryAuth builds a RegistryAuth from the configured credentials and the Docker config
+// file at dockerConfigPath. When dockerConfigPath is empty, $DOCKER_CONFIG/config.json or
+// ~/.docker/config.json is used if it exists.
+func LoadRegistryAuth(dockerConfigPath string, credentials []RegistryCredential, logger *slog.Logger) (*RegistryAuth, error) {
+	auth := &RegistryAuth{configured: make(map[string]RegistryCredential), logger: logger}
+	for _, cred := range credentials {
+		auth.configured[normalizeRegistryHost(cred.Server)] = cred
+	}
+
+	explicit := dockerConfigPath != ""
+	if !explicit {
+		dockerConfigPath = defaultDockerConfigPath()
+	}
+	if dockerConfigPath == "" {
+		return auth, nil
+	}
+	data, err := os.ReadFile(dockerConfigPath)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return auth, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read docker config %s: %w", dockerConfigPath, err)
+	}
+	auth.dockerConfig = new(dockerConfigFile)
+	if err := json.Unmarshal(data, auth.dockerConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse docker config %s: %w", dockerConfigPath, err)
+	}
+	return auth, nil
+}
+
+func defaultDockerConfigPath() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+// EncodedAuth returns the X-Registry-Auth value for pulling imageRef, or an empty string
+// when no credentials are known for its registry.
+func (a *RegistryAuth) EncodedAuth(imageRef string) (string, error) {
+	if a == nil {
+		return "", nil
+	}
+	host, err := registryHost(imageRef)
+	if err != nil {
+		return "", err
+	}
+	authConfig, found, err := a.lookup(host)
+	if err != nil || !found {
+		return "", err
+	}
+	return registry.EncodeAuthConfig(authConfig)
+}
+
+func (a *RegistryAuth) lookup(host string) (registry.AuthConfig, bool, error) {
+	serverAddress := host
+	if host == "docker.io" {
+		serverAddress = dockerHubAuthKey
+	}
+
+	if cred, ok := a.configured[host]; ok {
+		return registry.AuthConfig{Username: cred.Username, Password: cred.Password, ServerAddress: serverAddress}, true, nil
+	}
+	if a.dockerConfig == nil {
+		return registry.AuthConfig{}, false, nil
+	}
+
+	// Credential helpers take precedence over inline auths, like in the Docker CLI.
+	helper := a.dockerConfig.CredsStore
+	for key, h := range a.dockerConfig.CredHelpers {
+		if normalizeRegistryHost(key) == host {
+			helper = h
+		}
+	}
+	if helper != "" && !a.helperFailed(helper) {
+		authConfig, found, err := credentialsFromHelper(helper, serverAddress)
+		if err != nil {
+			// A helper named in config.json but not installed, such as "desktop" in a config
+			// copied from a workstation, must not break pulls that need no credentials.
+			a.logger.Warn("Credential helper failed, using docker config auths or anonymous access", "helper", helper, "registry", host, "error", err)
+			a.mu.Lock()
+			if a.failedHelpers == nil {
+				a.failedHelpers = make(map[string]bool)
+			}
+			a.failedHelpers[helper] = true
+			a.mu.Unlock()
+		} else if found {
+			return authConfig, true, nil
+		}
+	}
+
+	for key, entry := range a.dockerConfig.Auths {
+		if normalizeRegistryHost(key) != host {
+			continue
+		}
+		authConfig := registry.AuthConfig{
+			Username:      entry.Username,
+			Password:      entry.Password,
+			IdentityToken: entry.IdentityToken,
+			ServerAddress: serverAddress,
+		}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return registry.AuthConfig{}, false, fmt.Errorf("invalid auth entry for %s in docker config: %w", key, err)
+			}
+			username, password, ok := strings.Cut(string(decoded), ":")
+			if !ok {
+				return registry.AuthConfig{}, false, fmt.Errorf("invalid auth entry for %s in docker config", key)
+			}
+			authConfig.Username, authConfig.Password = username, password
+		}
+		return authConfig, true, nil
+	}
+	return registry.AuthConfig{}, false, nil
+}
+
+func (a *RegistryAuth) helperFailed(helper string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.failedHelpers[helper]
+}
+
+// credentialsFromHelper runs docker-credential-<helper> get for serverAddress.
+func credentialsFromHelper(helper, serverAddress string) (registry.AuthConfig, bool, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(serverAddress)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		output := strings.TrimSpace(stdout.String() + stderr.String())
+		if strings.Contains(output, "credentials not found") {
+			return registry.AuthConfig{}, false, nil
+		}
+		return registry.AuthConfig{}, false, fmt.Errorf("credential helper %s failed: %w: %s", helper, err, output)
+	}
+
+	var creds struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return registry.AuthConfig{}, false, fmt.Errorf("credential helper %s returned invalid output: %w", helper, err)
+	}
+	authConfig := registry.AuthConfig{ServerAddress: serverAddress}
+	if creds.Username == "<token>" {
+		authConfig.IdentityToken = creds.Secret
+	} else {
+		authConfig.Username, authConfig.Password = creds.Username, creds.Secret
+	}
+	return authConfig, true, nil
+}
+
+// registryHost returns the registry host of an image reference, e.g. "docker.io" or "ghcr.io".
+func registryHost(imageRef string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(imageRef)
+	if err != nil {
+		return "", fmt.Errorf("invalid image reference %s: %w", imageRef, err)
+	}
+	return reference.Domain(named), nil
+}
+
+// normalizeRegistryHost reduces registry keys such as "https://index.docker.io/v1/" or
+// "registry.example.com/" to a bare host name comparable with registryHost.
+func normalizeRegistryHost(server string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	host, _, _ = strings.Cut(host, "/")
+	switch host {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return "docker.io"
+	}
+	return host
+}
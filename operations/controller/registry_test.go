@@ -0,0 +1,226 @@
+package controller
+
+import (
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/moby/moby/api/types/registry"
+)
+
+func TestNormalizeRegistryHost(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "docker.io", want: "docker.io"},
+		{in: "https://index.docker.io/v1/", want: "docker.io"},
+		{in: "index.docker.io", want: "docker.io"},
+		{in: "registry-1.docker.io", want: "docker.io"},
+		{in: "registry.hub.docker.com", want: "docker.io"},
+		{in: "ghcr.io", want: "ghcr.io"},
+		{in: "registry.example.com/", want: "registry.example.com"},
+		{in: "https://registry.example.com/v2/", want: "registry.example.com"},
+		{in: "http://localhost:5000", want: "localhost:5000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := normalizeRegistryHost(tt.in); got != tt.want {
+				t.Errorf("normalizeRegistryHost(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryHost(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "nginx", want: "docker.io"},
+		{in: "library/nginx:1.27", want: "docker.io"},
+		{in: "ghcr.io/acme/app:latest", want: "ghcr.io"},
+		{in: "localhost:5000/app", want: "localhost:5000"},
+		{in: "registry.example.com/team/app@sha256:" + strings.Repeat("a", 64), want: "registry.example.com"},
+		{in: "Invalid:Ref", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := registryHost(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("registryHost(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("registryHost(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryAuthLookup(t *testing.T) {
+	// Fake credential helpers: "ok" knows ghcr.io, "empty" knows nothing and "broken" fails.
+	bin := t.TempDir()
+	writeHelper(t, bin, "ok", `read server
+if [ "$server" = ghcr.io ]; then echo '{"Username":"helper","Secret":"s3cret"}'; exit 0; fi
+if [ "$server" = token.example.com ]; then echo '{"Username":"<token>","Secret":"tok"}'; exit 0; fi
+echo "credentials not found in native keychain"; exit 1`)
+	writeHelper(t, bin, "empty", `echo "credentials not found in native keychain"; exit 1`)
+	writeHelper(t, bin, "broken", `echo "cannot reach keychain" >&2; exit 1`)
+	t.Setenv("PATH", bin)
+
+	basic := func(user, password string) string {
+		return base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+	}
+	auths := map[string]dockerAuthEntry{
+		"https://index.docker.io/v1/": {Auth: basic("hubuser", "hubpass")},
+		"ghcr.io":                     {Username: "inline", Password: "inlinepass"},
+		"registry.example.com":        {IdentityToken: "idtoken"},
+	}
+
+	tests := []struct {
+		name       string
+		configured map[string]RegistryCredential
+		config     *dockerConfigFile
+		host       string
+		want       registry.AuthConfig
+		wantFound  bool
+		wantErr    string
+	}{
+		{
+			name: "no docker config",
+			host: "ghcr.io",
+		},
+		{
+			name:       "configured credentials take precedence",
+			configured: map[string]RegistryCredential{"ghcr.io": {Server: "ghcr.io", Username: "cfg", Password: "cfgpass"}},
+			config:     &dockerConfigFile{Auths: auths, CredsStore: "ok"},
+			host:       "ghcr.io",
+			want:       registry.AuthConfig{Username: "cfg", Password: "cfgpass", ServerAddress: "ghcr.io"},
+			wantFound:  true,
+		},
+		{
+			name:      "auth entry is decoded and Docker Hub uses its legacy address",
+			config:    &dockerConfigFile{Auths: auths},
+			host:      "docker.io",
+			want:      registry.AuthConfig{Username: "hubuser", Password: "hubpass", ServerAddress: dockerHubAuthKey},
+			wantFound: true,
+		},
+		{
+			name:      "identity token entry",
+			config:    &dockerConfigFile{Auths: auths},
+			host:      "registry.example.com",
+			want:      registry.AuthConfig{IdentityToken: "idtoken", ServerAddress: "registry.example.com"},
+			wantFound: true,
+		},
+		{
+			name:   "unknown registry is anonymous",
+			config: &dockerConfigFile{Auths: auths},
+			host:   "quay.io",
+		},
+		{
+			name:      "credsStore takes precedence over auths",
+			config:    &dockerConfigFile{Auths: auths, CredsStore: "ok"},
+			host:      "ghcr.io",
+			want:      registry.AuthConfig{Username: "helper", Password: "s3cret", ServerAddress: "ghcr.io"},
+			wantFound: true,
+		},
+		{
+			name:      "helper token becomes an identity token",
+			config:    &dockerConfigFile{CredsStore: "ok"},
+			host:      "token.example.com",
+			want:      registry.AuthConfig{IdentityToken: "tok", ServerAddress: "token.example.com"},
+			wantFound: true,
+		},
+		{
+			name:      "credHelpers for the registry override credsStore",
+			config:    &dockerConfigFile{Auths: auths, CredsStore: "broken", CredHelpers: map[string]string{"ghcr.io": "ok"}},
+			host:      "ghcr.io",
+			want:      registry.AuthConfig{Username: "helper", Password: "s3cret", ServerAddress: "ghcr.io"},
+			wantFound: true,
+		},
+		{
+			name:      "helper without credentials falls back to auths",
+			config:    &dockerConfigFile{Auths: auths, CredsStore: "empty"},
+			host:      "ghcr.io",
+			want:      registry.AuthConfig{Username: "inline", Password: "inlinepass", ServerAddress: "ghcr.io"},
+			wantFound: true,
+		},
+		{
+			name:      "failing helper falls back to auths",
+			config:    &dockerConfigFile{Auths: auths, CredsStore: "broken"},
+			host:      "ghcr.io",
+			want:      registry.AuthConfig{Username: "inline", Password: "inlinepass", ServerAddress: "ghcr.io"},
+			wantFound: true,
+		},
+		{
+			name:   "missing helper falls back to anonymous access",
+			config: &dockerConfigFile{CredsStore: "desktop"},
+			host:   "docker.io",
+		},
+		{
+			name:    "invalid auth entry",
+			config:  &dockerConfigFile{Auths: map[string]dockerAuthEntry{"ghcr.io": {Auth: "not base64!"}}},
+			host:    "ghcr.io",
+			wantErr: "invalid auth entry for ghcr.io",
+		},
+		{
+			name:    "auth entry without a password separator",
+			config:  &dockerConfigFile{Auths: map[string]dockerAuthEntry{"ghcr.io": {Auth: base64.StdEncoding.EncodeToString([]byte("user"))}}},
+			host:    "ghcr.io",
+			wantErr: "invalid auth entry for ghcr.io",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := &RegistryAuth{configured: tt.configured, dockerConfig: tt.config, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+			got, found, err := auth.lookup(tt.host)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("lookup(%q) error = %v, want error containing %q", tt.host, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("lookup(%q) unexpected error: %v", tt.host, err)
+			}
+			if found != tt.wantFound || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lookup(%q) = %+v, %v; want %+v, %v", tt.host, got, found, tt.want, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestRegistryAuthSkipsFailedHelper(t *testing.T) {
+	bin := t.TempDir()
+	calls := filepath.Join(t.TempDir(), "calls")
+	writeHelper(t, bin, "broken", `echo x >> `+calls+`; exit 1`)
+	t.Setenv("PATH", bin)
+
+	auth := &RegistryAuth{dockerConfig: &dockerConfigFile{CredsStore: "broken"}, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	for range 3 {
+		if _, _, err := auth.lookup("ghcr.io"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "x"); n != 1 {
+		t.Errorf("helper ran %d times, want 1", n)
+	}
+}
+
+func writeHelper(t *testing.T, dir, name, script string) {
+	t.Helper()
+	path := filepath.Join(dir, "docker-credential-"+name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
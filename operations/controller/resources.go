@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+
+	"github.com/docker/go-units"
+	"github.com/moby/moby/api/types/container"
+	"gopkg.in/yaml.v3"
+)
+
+// DeployConfig holds the parts of a service's deploy section that apply outside of swarm.
+type DeployConfig struct {
+	Resources     ResourcesConfig      `yaml:"resources,omitempty"`
+	RestartPolicy *RestartPolicyConfig `yaml:"restart_policy,omitempty"`
+}
+
+// ResourcesConfig holds a service's resource limits and reservations.
+type ResourcesConfig struct {
+	Limits       *ResourceSpec `yaml:"limits,omitempty"`
+	Reservations *ResourceSpec `yaml:"reservations,omitempty"`
+}
+
+type ResourceSpec struct {
+	// CPUs is a fraction of CPUs, e.g. "0.5".
+	CPUs string `yaml:"cpus,omitempty"`
+	// Memory is a byte count or a size with a unit, e.g. "512m".
+	Memory string `yaml:"memory,omitempty"`
+	Pids   int64  `yaml:"pids,omitempty"`
+}
+
+// Ulimits holds a service's ulimits by name. Each accepts a single value, used for both the
+// soft and the hard limit, or a {soft, hard} mapping.
+type Ulimits map[string]Ulimit
+
+type Ulimit struct {
+	Soft int64 `yaml:"soft"`
+	Hard int64 `yaml:"hard"`
+}
+
+func (u *Ulimit) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var limit int64
+		if err := value.Decode(&limit); err != nil {
+			return err
+		}
+		*u = Ulimit{Soft: limit, Hard: limit}
+		return nil
+	}
+	type plain Ulimit
+	return value.Decode((*plain)(u))
+}
+
+// applyResources sets the resource limits of a service on its container's host configuration.
+// Limits from the deploy section may not conflict with the equivalent service level keys.
+func applyResources(service *Service, hostConfig *container.HostConfig) error {
+	var limits, reservations ResourceSpec
+	if service.Deploy != nil {
+		if service.Deploy.Resources.Limits != nil {
+			limits = *service.Deploy.Resources.Limits
+		}
+		if service.Deploy.Resources.Reservations != nil {
+			reservations = *service.Deploy.Resources.Reservations
+		}
+	}
+
+	cpus, err := mergeLimit("cpus", service.CPUs, limits.CPUs)
+	if err != nil {
+		return err
+	}
+	if cpus != "" {
+		n, err := strconv.ParseFloat(cpus, 64)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid cpus '%s'", cpus)
+		}
+		hostConfig.NanoCPUs = int64(n * 1e9)
+	}
+
+	memory, err := mergeLimit("memory", service.MemLimit, limits.Memory)
+	if err != nil {
+		return err
+	}
+	if hostConfig.Memory, err = parseBytes("memory limit", memory); err != nil {
+		return err
+	}
+	if hostConfig.MemoryReservation, err = parseBytes("memory reservation", reservations.Memory); err != nil {
+		return err
+	}
+	if hostConfig.ShmSize, err = parseBytes("shm_size", service.ShmSize); err != nil {
+		return err
+	}
+
+	if service.PidsLimit != 0 && limits.Pids != 0 && service.PidsLimit != limits.Pids {
+		return fmt.Errorf("pids_limit %d conflicts with deploy.resources.limits.pids %d", service.PidsLimit, limits.Pids)
+	}
+	if pids := max(service.PidsLimit, limits.Pids); pids != 0 {
+		hostConfig.PidsLimit = &pids
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(service.Ulimits)) {
+		u := service.Ulimits[name]
+		if u.Soft > u.Hard {
+			return fmt.Errorf("ulimit %s: soft limit %d exceeds hard limit %d", name, u.Soft, u.Hard)
+		}
+		hostConfig.Ulimits = append(hostConfig.Ulimits, &container.Ulimit{Name: name, Soft: u.Soft, Hard: u.Hard})
+	}
+	hostConfig.OomScoreAdj = service.OomScoreAdj
+	if hostConfig.OomScoreAdj < -1000 || hostConfig.OomScoreAdj > 1000 {
+		return fmt.Errorf("oom_score_adj %d is out of range [-1000, 1000]", hostConfig.OomScoreAdj)
+	}
+	return nil
+}
+
+// mergeLimit returns the limit set either on the service or in its deploy section.
+func mergeLimit(name, service, deploy string) (string, error) {
+	if service != "" && deploy != "" && service != deploy {
+		return "", fmt.Errorf("%s '%s' conflicts with deploy.resources.limits.%s '%s'", name, service, name, deploy)
+	}
+	if deploy != "" {
+		return deploy, nil
+	}
+	return service, nil
+}
+
+func parseBytes(name, value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := units.RAMInBytes(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s '%s': %w", name, value, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/moby/moby/api/types/container"
+)
+
+// Conditions of the deploy section's restart_policy.
+const (
+	restartConditionNone      = "none"
+	restartConditionOnFailure = "on-failure"
+	restartConditionAny       = "any"
+)
+
+// RestartPolicyConfig is a service's deploy.restart_policy. Only condition and max_attempts
+// apply outside of swarm.
+type RestartPolicyConfig struct {
+	Condition   string `yaml:"condition,omitempty"`
+	MaxAttempts int    `yaml:"max_attempts,omitempty"`
+	Delay       string `yaml:"delay,omitempty"`
+	Window      string `yaml:"window,omitempty"`
+}
+
+// restartPolicy translates a service's restart or deploy.restart_policy into a Docker restart
+// policy. Services without one, or with "no", get the zero policy, which Docker treats as "no".
+func restartPolicy(service *Service) (container.RestartPolicy, error) {
+	policy, err := parseRestart(service.Restart)
+	if err != nil {
+		return container.RestartPolicy{}, err
+	}
+	if service.Deploy == nil || service.Deploy.RestartPolicy == nil {
+		return policy, nil
+	}
+
+	deploy := container.RestartPolicy{}
+	switch rp := service.Deploy.RestartPolicy; rp.Condition {
+	case restartConditionNone:
+	case "", restartConditionAny:
+		deploy.Name = container.RestartPolicyAlways
+	case restartConditionOnFailure:
+		deploy = container.RestartPolicy{Name: container.RestartPolicyOnFailure, MaximumRetryCount: rp.MaxAttempts}
+	default:
+		return container.RestartPolicy{}, fmt.Errorf("unknown deploy.restart_policy condition '%s'", rp.Condition)
+	}
+	if service.Restart != "" && policy != deploy {
+		return container.RestartPolicy{}, fmt.Errorf("restart '%s' conflicts with deploy.restart_policy", service.Restart)
+	}
+	return deploy, nil
+}
+
+// parseRestart parses compose's restart: "no", "always", "on-failure[:max]" or "unless-stopped".
+func parseRestart(restart string) (container.RestartPolicy, error) {
+	name, maxRetries, hasMax := strings.Cut(restart, ":")
+	policy := container.RestartPolicy{}
+	switch container.RestartPolicyMode(name) {
+	case "", container.RestartPolicyDisabled:
+	case container.RestartPolicyAlways, container.RestartPolicyUnlessStopped:
+		policy.Name = container.RestartPolicyMode(name)
+	case container.RestartPolicyOnFailure:
+		policy.Name = container.RestartPolicyOnFailure
+		if hasMax {
+			n, err := strconv.Atoi(maxRetries)
+			if err != nil || n < 0 {
+				return container.RestartPolicy{}, fmt.Errorf("invalid restart '%s': maximum retry count must be a non-negative number", restart)
+			}
+			policy.MaximumRetryCount = n
+		}
+		return policy, nil
+	default:
+		return container.RestartPolicy{}, fmt.Errorf("unknown restart policy '%s'", restart)
+	}
+	if hasMax {
+		return container.RestartPolicy{}, fmt.Errorf("invalid restart '%s': only on-failure takes a maximum retry count", restart)
+	}
+	return policy, nil
+}
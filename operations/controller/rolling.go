@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/containerd/errdefs"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/client"
+)
+
+// replacementSuffix is appended to the container name while a start-first update is in progress.
+const replacementSuffix = "_watcher_next"
+
+// startupGracePeriod is how long a replacement without a healthcheck must keep running
+// before the old container is removed.
+const startupGracePeriod = 5 * time.Second
+
+// recreateStartFirst replaces oldContainer without downtime: the new container is started under
+// a temporary name, and only once it is healthy (or, without a healthcheck, still running after
+// a grace period) is the old container stopped and removed and the new one renamed. If anything
+// fails before the old container is stopped, the new container is removed and the old one keeps
+// running. Services publishing fixed host ports cannot run two containers at once and fail here.
+func recreateStartFirst(ctx context.Context, cli *client.Client, projectName string, serviceName string, service *Service, oldContainer container.Summary, opts ApplyOptions, logger *slog.Logger) (string, error) {
+	spec, err := buildContainerSpec(projectName, serviceName, service)
+	if err != nil {
+		return "", err
+	}
+	tempName := spec.Name + replacementSuffix
+
+	// Clean up a replacement left behind by an interrupted update.
+	if err := cli.ContainerRemove(ctx, tempName, container.RemoveOptions{Force: true}); err != nil && !errdefs.IsNotFound(err) {
+		return "", fmt.Errorf("failed to remove stale replacement container %s: %w", tempName, err)
+	}
+
+	logger.Info("Starting replacement container", "service_name", serviceName, "container_name", tempName)
+	resp, err := cli.ContainerCreate(ctx, spec.Config, spec.HostConfig, spec.Networking, nil, tempName)
+	if err != nil {
+		return "", fmt.Errorf("failed to create replacement container: %w", err)
+	}
+	discard := func(cause error) (string, error) {
+		if err := cli.ContainerRemove(ctx, resp.ID, container.RemoveOptions{Force: true}); err != nil {
+			logger.Error("Failed to remove replacement container", "container_id", resp.ID[:12], "error", err)
+		}
+		return "", cause
+	}
+	if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return discard(fmt.Errorf("failed to start replacement container: %w", err))
+	}
+	if err := waitForReplacement(ctx, cli, service, resp.ID, opts, logger); err != nil {
+		return discard(err)
+	}
+
+	logger.Info("Replacement is ready. Stopping old container", "service_name", serviceName, "container_id", oldContainer.ID[:12])
+	if err := cli.ContainerStop(ctx, oldContainer.ID, container.StopOptions{}); err != nil {
+		return discard(fmt.Errorf("failed to stop old container: %w", err))
+	}
+	if err := cli.ContainerRemove(ctx, oldContainer.ID, container.RemoveOptions{}); err != nil {
+		return resp.ID, fmt.Errorf("failed to remove old container: %w", err)
+	}
+	if err := cli.ContainerRename(ctx, resp.ID, spec.Name); err != nil {
+		return resp.ID, fmt.Errorf("failed to rename replacement container to %s: %w", spec.Name, err)
+	}
+	logger.Info("Start-first update completed", "service_name", serviceName, "container_id", resp.ID[:12])
+	return resp.ID, nil
+}
+
+// waitForReplacement waits until a replacement container is ready to take over.
+func waitForReplacement(ctx context.Context, cli *client.Client, service *Service, containerID string, opts ApplyOptions, logger *slog.Logger) error {
+	if service.hasHealthCheck() {
+		return waitForHealthCheck(ctx, cli, containerID, healthWaitTimeout(service, opts), logger)
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(startupGracePeriod):
+	}
+	inspect, err := cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return fmt.Errorf("failed to inspect replacement container: %w", err)
+	}
+	if inspect.State == nil || !inspect.State.Running {
+		return fmt.Errorf("replacement container exited during startup")
+	}
+	return nil
+}
+
+// isReplacement reports whether a container is a start-first replacement that was never renamed.
+func isReplacement(c container.Summary) bool {
+	for _, name := range c.Names {
+		if strings.HasSuffix(name, replacementSuffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/moby/moby/api/types/container"
+	"gopkg.in/yaml.v3"
+)
+
+// servicePrefix marks a network_mode, pid or ipc that shares the namespace of another service.
+const servicePrefix = "service:"
+
+// StringList accepts a single string or a list of strings, as dns, dns_search and tmpfs do.
+type StringList []string
+
+func (l *StringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = StringList{value.Value}
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// ExtraHosts holds a service's extra /etc/hosts entries as "host:ip". It accepts the list
+// syntax (- host:ip or - host=ip) and the map syntax (host: ip).
+type ExtraHosts []string
+
+func (h *ExtraHosts) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.SequenceNode:
+		var list []string
+		if err := value.Decode(&list); err != nil {
+			return err
+		}
+		hosts := make(ExtraHosts, 0, len(list))
+		for _, item := range list {
+			if host, ip, ok := strings.Cut(item, "="); ok {
+				item = host + ":" + ip
+			}
+			hosts = append(hosts, item)
+		}
+		*h = hosts
+	case yaml.MappingNode:
+		hosts := make(ExtraHosts, 0, len(value.Content)/2)
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			hosts = append(hosts, value.Content[i].Value+":"+value.Content[i+1].Value)
+		}
+		*h = hosts
+	default:
+		return fmt.Errorf("line %d: extra_hosts must be a list or a map", value.Line)
+	}
+	return nil
+}
+
+// Sysctls holds a service's namespaced kernel parameters. It accepts both the list syntax
+// (- key=value) and the map syntax (key: value).
+type Sysctls map[string]string
+
+func (s *Sysctls) UnmarshalYAML(value *yaml.Node) error {
+	sysctls, err := decodeKeyValues(value, "sysctls")
+	if err != nil {
+		return err
+	}
+	*s = sysctls
+	return nil
+}
+
+type LoggingConfig struct {
+	Driver  string            `yaml:"driver,omitempty"`
+	Options map[string]string `yaml:"options,omitempty"`
+}
+
+// applyRuntimeOptions sets the service's process, namespace, security and logging options on
+// its container's configuration.
+func applyRuntimeOptions(service *Service, config *container.Config, hostConfig *container.HostConfig) error {
+	config.Entrypoint = []string(service.Entrypoint)
+	config.WorkingDir = service.WorkingDir
+	config.User = service.User
+	config.Hostname = service.Hostname
+	config.Domainname = service.Domainname
+	config.StopSignal = service.StopSignal
+	config.Tty = service.Tty
+	config.OpenStdin = service.StdinOpen
+	if service.StopGracePeriod != "" {
+		d, err := time.ParseDuration(service.StopGracePeriod)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid stop_grace_period '%s'", service.StopGracePeriod)
+		}
+		seconds := int(d.Round(time.Second) / time.Second)
+		config.StopTimeout = &seconds
+	}
+
+	hostConfig.Privileged = service.Privileged
+	hostConfig.CapAdd = service.CapAdd
+	hostConfig.CapDrop = service.CapDrop
+	hostConfig.SecurityOpt = service.SecurityOpt
+	hostConfig.DNS = service.DNS
+	hostConfig.DNSSearch = service.DNSSearch
+	hostConfig.ExtraHosts = service.ExtraHosts
+	hostConfig.Init = service.Init
+	hostConfig.ReadonlyRootfs = service.ReadOnly
+	hostConfig.NetworkMode = container.NetworkMode(service.NetworkMode)
+	hostConfig.PidMode = container.PidMode(service.Pid)
+	hostConfig.IpcMode = container.IpcMode(service.Ipc)
+	if len(service.Sysctls) > 0 {
+		hostConfig.Sysctls = service.Sysctls
+	}
+	if service.Logging != nil {
+		hostConfig.LogConfig = container.LogConfig{Type: service.Logging.Driver, Config: service.Logging.Options}
+	}
+
+	for _, tmpfs := range service.Tmpfs {
+		path, options, _ := strings.Cut(tmpfs, ":")
+		if hostConfig.Tmpfs == nil {
+			hostConfig.Tmpfs = make(map[string]string)
+		}
+		hostConfig.Tmpfs[path] = options
+	}
+
+	for _, device := range service.Devices {
+		mapping, err := parseDevice(device)
+		if err != nil {
+			return err
+		}
+		hostConfig.Devices = append(hostConfig.Devices, mapping)
+	}
+	return nil
+}
+
+// parseDevice parses a device in the "host_path[:container_path[:permissions]]" syntax.
+func parseDevice(device string) (container.DeviceMapping, error) {
+	parts := strings.Split(device, ":")
+	if len(parts) > 3 || !strings.HasPrefix(parts[0], "/") {
+		return container.DeviceMapping{}, fmt.Errorf("invalid device '%s'", device)
+	}
+	mapping := container.DeviceMapping{PathOnHost: parts[0], PathInContainer: parts[0], CgroupPermissions: "rwm"}
+	if len(parts) > 1 {
+		mapping.PathInContainer = parts[1]
+	}
+	if len(parts) > 2 {
+		mapping.CgroupPermissions = parts[2]
+	}
+	return mapping, nil
+}
+
+// resolveServiceNamespaces replaces "service:<name>" in a service's network_mode, pid and ipc
+// with the container of that service, and makes the service depend on it.
+func resolveServiceNamespaces(compose *Compose, serviceName string, service *Service) error {
+	if service.NetworkMode != "" && len(service.Networks) > 0 {
+		return fmt.Errorf("network_mode and networks cannot be combined")
+	}
+	for _, mode := range []*string{&service.NetworkMode, &service.Pid, &service.Ipc} {
+		target, ok := strings.CutPrefix(*mode, servicePrefix)
+		if !ok {
+			continue
+		}
+		other, ok := compose.Services[target]
+		if !ok || target == serviceName {
+			return fmt.Errorf("'%s' does not refer to another service", *mode)
+		}
+		containerName := other.ContainerName
+		if containerName == "" {
+			containerName = target
+		}
+		*mode = "container:" + containerName
+		if !slices.ContainsFunc(service.DependsOn, func(d Dependency) bool { return d.Service == target }) {
+			service.DependsOn = append(service.DependsOn, Dependency{Service: target, Condition: ConditionStarted, Required: true})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// schemaProvider is implemented by types whose YAML form differs from their Go type, such as
+// types converting a long syntax on unmarshalling. yamlSchema returns a value of the type the
+// YAML form is checked against.
+type schemaProvider interface {
+	yamlSchema() any
+}
+
+var schemaProviderType = reflect.TypeFor[schemaProvider]()
+
+// checkKeys reports the first key in node that t does not define, so unsupported compose
+// options fail loudly instead of being dropped. Extension keys (x-*) are always allowed.
+func checkKeys(node *yaml.Node, t reflect.Type, path string) error {
+	node = resolveAlias(node)
+	if node == nil {
+		return nil
+	}
+	if t.Implements(schemaProviderType) {
+		t = reflect.TypeOf(reflect.Zero(t).Interface().(schemaProvider).yamlSchema())
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case node.Kind == yaml.MappingNode && t.Kind() == reflect.Struct:
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i].Value, node.Content[i+1]
+			if key == "<<" {
+				if err := checkMergedKeys(value, t, path); err != nil {
+					return err
+				}
+				continue
+			}
+			field, ok := fields[key]
+			if !ok {
+				if strings.HasPrefix(key, "x-") {
+					continue
+				}
+				return fmt.Errorf("line %d: unsupported key '%s'", node.Content[i].Line, joinPath(path, key))
+			}
+			if err := checkKeys(value, field, joinPath(path, key)); err != nil {
+				return err
+			}
+		}
+	case node.Kind == yaml.MappingNode && t.Kind() == reflect.Map:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if err := checkKeys(node.Content[i+1], t.Elem(), joinPath(path, key)); err != nil {
+				return err
+			}
+		}
+	case node.Kind == yaml.SequenceNode && t.Kind() == reflect.Slice:
+		for i, item := range node.Content {
+			if err := checkKeys(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkMergedKeys checks the mappings merged into a mapping with "<<".
+func checkMergedKeys(node *yaml.Node, t reflect.Type, path string) error {
+	node = resolveAlias(node)
+	if node.Kind != yaml.SequenceNode {
+		return checkKeys(node, t, path)
+	}
+	for _, item := range node.Content {
+		if err := checkKeys(item, t, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// yamlFields returns the types of a struct's fields by YAML key.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		wantErr string
+	}{
+		{
+			name: "supported keys",
+			doc:  "services: {web: {image: nginx, ports: ['80:80'], healthcheck: {test: [CMD, true], interval: 5s}}}",
+		},
+		{
+			name: "version and name are accepted",
+			doc:  "version: '3.8'\nname: demo\nservices: {web: {image: nginx}}",
+		},
+		{
+			name: "extension keys are allowed at any level",
+			doc:  "x-common: {anything: [1, 2]}\nservices: {web: {image: nginx, x-note: hi, healthcheck: {x-extra: 1}}}",
+		},
+		{
+			name:    "unknown top-level key",
+			doc:     "configs: {app: {file: ./app.conf}}",
+			wantErr: "line 1: unsupported key 'configs'",
+		},
+		{
+			name:    "unknown service key",
+			doc:     "services:\n  web:\n    image: nginx\n    secrets: [token]",
+			wantErr: "line 4: unsupported key 'services.web.secrets'",
+		},
+		{
+			name:    "unknown healthcheck key",
+			doc:     "services: {web: {healthcheck: {test: [NONE], timeuot: 5s}}}",
+			wantErr: "line 1: unsupported key 'services.web.healthcheck.timeuot'",
+		},
+		{
+			name: "depends_on short syntax",
+			doc:  "services: {web: {depends_on: [db]}, db: {image: postgres}}",
+		},
+		{
+			name: "depends_on long syntax",
+			doc:  "services: {web: {depends_on: {db: {condition: service_healthy, required: false, restart: true}}}}",
+		},
+		{
+			name:    "unknown depends_on key",
+			doc:     "services:\n  web:\n    depends_on:\n      db:\n        condtion: service_healthy",
+			wantErr: "line 5: unsupported key 'services.web.depends_on.db.condtion'",
+		},
+		{
+			name: "long syntax port",
+			doc:  "services: {web: {ports: ['80:80', {target: 80, published: 8080, host_ip: 127.0.0.1, protocol: tcp, mode: host}]}}",
+		},
+		{
+			name:    "unknown long syntax port key",
+			doc:     "services: {web: {ports: ['80:80', {target: 80, app_protocol: http}]}}",
+			wantErr: "line 1: unsupported key 'services.web.ports[1].app_protocol'",
+		},
+		{
+			name:    "unknown network key",
+			doc:     "networks: {front: {driver: bridge, ipam: {}}}",
+			wantErr: "line 1: unsupported key 'networks.front.ipam'",
+		},
+		{
+			name: "merge keys are checked against the mapping they merge into",
+			doc:  "x-base: &base {restart: always}\nservices: {web: {<<: *base, image: nginx}}",
+		},
+		{
+			name: "merge key lists",
+			doc:  "x-a: &a {restart: always}\nx-b: &b {user: app}\nservices: {web: {<<: [*a, *b], image: nginx}}",
+		},
+		{
+			name:    "unknown key in a merged mapping",
+			doc:     "x-base: &base {restrat: always}\nservices: {web: {<<: *base, image: nginx}}",
+			wantErr: "line 1: unsupported key 'services.web.restrat'",
+		},
+		{
+			name:    "unknown key behind an alias",
+			doc:     "x-hc: &hc {test: [NONE], every: 5s}\nservices: {web: {healthcheck: *hc}}",
+			wantErr: "line 1: unsupported key 'services.web.healthcheck.every'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkKeys(parseNode(t, tt.doc), reflect.TypeFor[Compose](), "")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("checkKeys() unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("checkKeys() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
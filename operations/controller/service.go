@@ -2,131 +2,476 @@ package controller
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/docker/go-connections/nat"
 	"github.com/moby/moby/api/types/container"
 	"github.com/moby/moby/api/types/image"
 	"github.com/moby/moby/api/types/network"
 	"github.com/moby/moby/client"
+	"github.com/sithukyaw666/watcher/metrics"
 	"github.com/sithukyaw666/watcher/utils"
 	"io"
 	"log/slog"
+	"maps"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 )
 
+// configHashLabel stores the hash of the configuration a container was created from,
+// so configuration drift can be detected on later cycles.
+const configHashLabel = "com.docker.compose.config-hash"
+
+// Docker's healthcheck defaults, used to derive how long to wait for a container to become healthy.
+const (
+	defaultHealthInterval = 30 * time.Second
+	defaultHealthRetries  = 3
+)
+
+// healthPollInterval is how often a container's health status is checked while waiting.
+const healthPollInterval = 5 * time.Second
+
 // ReconcileServices handles the reconciliation of all services defined in the compose configuration
 // against the actual running containers. It creates new services or updates existing ones as needed.
-func ReconcileServices(ctx context.Context, cli *client.Client, projectName string, compose *Compose, actualState map[string]container.Summary, logger *slog.Logger) error {
+// Every decision is recorded in plan; when plan.DryRun is set no changes are made to Docker.
+// Services are reconciled by dependency level: the services of a level only depend on earlier
+// levels and are reconciled concurrently, up to opts.Parallelism at a time. Their logs and
+// actions are written in service name order once the level is done.
+// Services that fail to update, or that are (re)created and never become healthy, do not stop
+// the remaining services from being reconciled but are reported in the returned error.
+func ReconcileServices(ctx context.Context, cli *client.Client, projectName string, compose *Compose, actualState map[string]container.Summary, opts ApplyOptions, plan *Plan, logger *slog.Logger) error {
 	depMap := make(map[string][]string)
 	for name, service := range compose.Services {
-		depMap[name] = service.DependsOn
+		depMap[name] = []string{}
+		for _, dep := range service.DependsOn {
+			// Optional dependencies on services that are not defined are ignored.
+			if _, defined := compose.Services[dep.Service]; defined || dep.Required {
+				depMap[name] = append(depMap[name], dep.Service)
+			}
+		}
 	}
 
-	orderServices, err := utils.ResolveDependencyOrder(depMap)
+	levels, err := utils.ResolveDependencyLevels(depMap)
 	if err != nil {
 		logger.Error("Failed to resolve service dependency order", "error", err)
 		return err
 	}
-	logger.Info("Service reconciliation order", "order", orderServices)
-	for _, serviceName := range orderServices {
-		desiredService := compose.Services[serviceName]
-		logger.Info("Reconciling service", "service_name", serviceName)
-
-		for _, depName := range desiredService.DependsOn {
-			depService := compose.Services[depName]
-			depContainer, ok := actualState[depName]
-			if !ok {
-				logger.Error("Dependency container not found in actual state.", "service", serviceName, "dependency", depName)
-				return fmt.Errorf("dependency '%s' for service '%s' not found", depName, serviceName)
+	repair := len(opts.Services) > 0
+	if repair {
+		for i, level := range levels {
+			levels[i] = selectServices(level, opts.Services)
+		}
+	}
+	logger.Info("Service reconciliation order", "levels", levels)
+
+	// Services are checked against the desired state before anything is changed, so the
+	// orphan list reflects the containers that existed when the cycle started.
+	orphans := make(map[string]container.Summary)
+	for serviceName, serviceContainer := range actualState {
+		if _, existsInDesired := compose.Services[serviceName]; !existsInDesired && !repair {
+			orphans[serviceName] = serviceContainer
+		}
+	}
+
+	r := &serviceReconciler{
+		cli:         cli,
+		projectName: projectName,
+		compose:     compose,
+		actualState: actualState,
+		oneShot:     oneShotServices(compose),
+		failed:      make(map[string]bool),
+		opts:        opts,
+		plan:        plan,
+		repair:      repair,
+	}
+	workers := max(opts.Parallelism, 1)
+
+	var failures []error
+	for _, level := range levels {
+		if len(level) == 0 {
+			continue
+		}
+		results := make([]serviceResult, len(level))
+		sem := make(chan struct{}, workers)
+		var wg sync.WaitGroup
+		for i, serviceName := range level {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				results[i] = r.reconcile(ctx, serviceName, logger)
+			}()
+		}
+		wg.Wait()
+
+		// Results are merged in service name order so logs and plans do not depend on scheduling.
+		var fatal error
+		for i, serviceName := range level {
+			result := results[i]
+			result.logs.flush(ctx)
+			plan.Actions = append(plan.Actions, result.plan.Actions...)
+			if result.container != nil {
+				actualState[serviceName] = *result.container
 			}
-			if depService.HealthCheck != nil && len(depService.HealthCheck.Test) > 0 {
-				logger.Info("Waiting for dependency to be healthy", "service", serviceName, "dependency", depName)
-				if err := waitForHealthCheck(ctx, cli, depContainer.ID, logger); err != nil {
-					logger.Error("Dependency failed health check", "service", serviceName, "dependency", depName, "error", err)
-				}
+			if len(result.errs) > 0 {
+				failures = append(failures, result.errs...)
+				r.failed[serviceName] = true
 			}
+			if result.fatal != nil && fatal == nil {
+				fatal = result.fatal
+			}
+		}
+		if fatal != nil {
+			// Keep the failures collected so far, they are often the root cause.
+			return errors.Join(append(failures, fatal)...)
 		}
+	}
+
+	logger.Info("Checking for orphan services to prune...")
+	for _, serviceName := range slices.Sorted(maps.Keys(orphans)) {
+		serviceContainer := orphans[serviceName]
+		plan.add(KindService, serviceName, ActionRemove, "orphaned")
+		if plan.DryRun {
+			logger.Info("Dry run: would remove orphaned service", "service_name", serviceName)
+			continue
+		}
+		logger.Info("Found orphaned service. Removing...", "service_name", serviceName)
+
+		logger.Info("Stopping container", "container_id", serviceContainer.ID[:12])
+		if err := cli.ContainerStop(ctx, serviceContainer.ID, container.StopOptions{}); err != nil {
+			logger.Error("Failed to stop orphaned container", "error", err)
+			continue
+		}
+		logger.Info("Removing container", "container_id", serviceContainer.ID[:12])
+		if err := cli.ContainerRemove(ctx, serviceContainer.ID, container.RemoveOptions{}); err != nil {
+			logger.Error("Failed to remove orphaned container", "error", err)
+			continue
+		}
+	}
+	return errors.Join(failures...)
+}
 
-		if actualContainer, ok := actualState[serviceName]; ok {
-			logger.Info("Service exists. Checking for image updates...", "service_name", serviceName)
-			if err := pullImage(ctx, cli, desiredService.Image, logger); err != nil {
-				logger.Warn("Could not pull image. Skipping update check.", "image", desiredService.Image, "error", err)
+// serviceReconciler holds the state shared by the services of one ReconcileServices run.
+// While a dependency level is reconciled, actualState, failed and plan are only read; each
+// service reports its changes in a serviceResult that is merged once the level is done.
+type serviceReconciler struct {
+	cli         *client.Client
+	projectName string
+	compose     *Compose
+	actualState map[string]container.Summary
+	oneShot     map[string]bool
+	failed      map[string]bool
+	opts        ApplyOptions
+	plan        *Plan
+	repair      bool
+}
+
+// serviceResult is the outcome of reconciling a single service.
+type serviceResult struct {
+	plan *Plan
+	logs *logBuffer
+	// container replaces the service's entry in the actual state when set.
+	container *container.Summary
+	errs      []error
+	// fatal stops the reconciliation after the current level.
+	fatal error
+}
+
+func (res *serviceResult) fail(err error) {
+	res.errs = append(res.errs, err)
+}
+
+// reconcile brings a single service to its desired state.
+func (r *serviceReconciler) reconcile(ctx context.Context, serviceName string, baseLogger *slog.Logger) serviceResult {
+	result := serviceResult{
+		plan: &Plan{Project: r.plan.Project, DryRun: r.plan.DryRun, Actions: []Action{}},
+		logs: &logBuffer{},
+	}
+	logger := result.logs.logger(baseLogger)
+	cli, projectName, opts, plan := r.cli, r.projectName, r.opts, result.plan
+	desiredService := r.compose.Services[serviceName]
+	logger.Info("Reconciling service", "service_name", serviceName)
+
+	for _, dep := range desiredService.DependsOn {
+		if opts.AbortOnDependencyFailure && dep.Required && r.failed[dep.Service] {
+			logger.Error("Skipping service because its dependency failed", "service", serviceName, "dependency", dep.Service)
+			result.fail(fmt.Errorf("service '%s' skipped: dependency '%s' failed", serviceName, dep.Service))
+			return result
+		}
+		depService := r.compose.Services[dep.Service]
+		depContainer, ok := r.actualState[dep.Service]
+		if !ok {
+			if !dep.Required {
+				logger.Warn("Optional dependency not found, continuing without it.", "service", serviceName, "dependency", dep.Service)
 				continue
 			}
-			desiredImg, err := cli.ImageInspect(ctx, desiredService.Image)
-			if err != nil {
-				logger.Warn("Could not inspect image. Skipping update check.", "image", desiredService.Image, "error", err)
+			logger.Error("Dependency container not found in actual state.", "service", serviceName, "dependency", dep.Service)
+			result.fatal = fmt.Errorf("dependency '%s' for service '%s' not found", dep.Service, serviceName)
+			return result
+		}
+		if plan.DryRun || dep.Condition == ConditionStarted {
+			continue
+		}
+		logger.Info("Waiting for dependency", "service", serviceName, "dependency", dep.Service, "condition", dep.Condition)
+		if err := waitForDependency(ctx, cli, dep, &depService, depContainer, opts, logger); err != nil {
+			if !dep.Required {
+				logger.Warn("Optional dependency did not meet its condition, continuing without it.", "service", serviceName, "dependency", dep.Service, "error", err)
 				continue
 			}
-			if actualContainer.ImageID != desiredImg.ID {
-				logger.Info("Image has change for service. Re-creating...", "service_name", serviceName)
-				logger.Info("Stopping old container", "container_id", actualContainer.ID[:12])
-				if err := cli.ContainerStop(ctx, actualContainer.ID, container.StopOptions{}); err != nil {
-					logger.Error("Failed to stop container", "error", err)
-					continue
-				}
-				if err := cli.ContainerRemove(ctx, actualContainer.ID, container.RemoveOptions{}); err != nil {
-					logger.Error("Failed to remove container", "error", err)
-					continue
-				}
-				if err := createService(ctx, cli, projectName, serviceName, &desiredService, logger); err != nil {
-					logger.Error("Failed to create new service", "error", err)
-
-				}
-			} else {
-				if actualContainer.State != "running" {
-					logger.Warn("Container exists but is not running. Starting...", "service_name", serviceName, "container_id", actualContainer.ID[:12], "current_status", actualContainer.State)
-					if err := cli.ContainerStart(ctx, actualContainer.ID, container.StartOptions{}); err != nil {
-						logger.Error("Failed to start the container", "service_name", serviceName)
-					} else {
-						logger.Info("Container started successfully.", "service_name", serviceName)
-					}
-				} else {
-					logger.Info("Service is up-to-date and running", "service_name", serviceName)
-				}
+			logger.Error("Dependency did not meet its condition", "service", serviceName, "dependency", dep.Service, "condition", dep.Condition, "error", err)
+			result.fail(fmt.Errorf("dependency '%s' of service '%s': %w", dep.Service, serviceName, err))
+			if opts.AbortOnDependencyFailure {
+				return result
 			}
+		}
+	}
 
-		} else {
-			logger.Info("Service not found. Creating...", "service_name", serviceName)
-			if err := createService(ctx, cli, projectName, serviceName, &desiredService, logger); err != nil {
-				logger.Error("Failed to create new service", "error", err)
-			}
+	actualContainer, ok := r.actualState[serviceName]
+	if !ok {
+		plan.add(KindService, serviceName, ActionCreate, "not found")
+		if plan.DryRun {
+			logger.Info("Dry run: would create service", "service_name", serviceName)
+			// Record the planned service so its dependents can be planned as well.
+			result.container = &container.Summary{}
+			return result
+		}
+		logger.Info("Service not found. Creating...", "service_name", serviceName)
+		if err := ensureImage(ctx, cli, &desiredService, opts, logger); err != nil {
+			logger.Error("Image is not available", "service_name", serviceName, "error", err)
+			result.fail(fmt.Errorf("service '%s': %w", serviceName, err))
+			return result
+		}
+		containerID, err := createService(ctx, cli, projectName, serviceName, &desiredService, opts, logger)
+		if err != nil {
+			logger.Error("Failed to create new service", "error", err)
+			result.fail(fmt.Errorf("service '%s': %w", serviceName, err))
+			return result
 		}
+		// Track the new container so services depending on it can find it.
+		result.container = &container.Summary{ID: containerID, State: "running"}
+		if err := verifyServiceHealth(ctx, cli, serviceName, &desiredService, containerID, opts, logger); err != nil {
+			result.fail(err)
+		}
+		return result
 	}
 
-	logger.Info("Checking for orphan services to prune...")
-	for serviceName, serviceContainer := range actualState {
-		if _, existsInDesired := compose.Services[serviceName]; !existsInDesired {
-			logger.Info("Found orphaned service. Removing...", "service_name", serviceName)
+	logger.Info("Service exists. Checking for image updates...", "service_name", serviceName)
+	desiredSpec, err := buildContainerSpec(projectName, serviceName, &desiredService)
+	if err != nil {
+		logger.Error("Could not build desired container config", "service_name", serviceName, "error", err)
+		result.fail(fmt.Errorf("service '%s': %w", serviceName, err))
+		return result
+	}
+	configChanged := actualContainer.Labels[configHashLabel] != desiredSpec.ConfigHash
 
-			logger.Info("Stopping container", "container_id", serviceContainer.ID[:12])
-			if err := cli.ContainerStop(ctx, serviceContainer.ID, container.StopOptions{}); err != nil {
-				logger.Error("Failed to stop orphaned container", "error", err)
-				continue
-			}
-			logger.Info("Removing container", "container_id", serviceContainer.ID[:12])
-			if err := cli.ContainerRemove(ctx, serviceContainer.ID, container.RemoveOptions{}); err != nil {
-				logger.Error("Failed to remove orphaned container", "error", err)
-				continue
+	imageChanged := false
+	if plan.DryRun || r.repair {
+		// Dry runs and repairs never pull, so only an image already present locally can be compared.
+		desiredImg, err := cli.ImageInspect(ctx, desiredService.Image)
+		if err != nil {
+			logger.Warn("Image not present locally, update check limited to configuration.", "image", desiredService.Image)
+		} else {
+			imageChanged = actualContainer.ImageID != desiredImg.ID
+		}
+	} else {
+		if err := ensureImage(ctx, cli, &desiredService, opts, logger); err != nil {
+			logger.Error("Image is not available", "service_name", serviceName, "image", desiredService.Image, "error", err)
+			result.fail(fmt.Errorf("service '%s': %w", serviceName, err))
+			return result
+		}
+		desiredImg, err := cli.ImageInspect(ctx, desiredService.Image)
+		if err != nil {
+			logger.Error("Could not inspect image", "service_name", serviceName, "image", desiredService.Image, "error", err)
+			result.fail(fmt.Errorf("service '%s': failed to inspect image %s: %w", serviceName, desiredService.Image, err))
+			return result
+		}
+		imageChanged = actualContainer.ImageID != desiredImg.ID
+	}
+
+	if imageChanged || configChanged {
+		plan.add(KindService, serviceName, ActionRecreate, changeReason(imageChanged, configChanged))
+		if plan.DryRun {
+			logger.Info("Dry run: would re-create service", "service_name", serviceName, "image_changed", imageChanged, "config_changed", configChanged)
+			return result
+		}
+		logger.Info("Service has changed. Re-creating...", "service_name", serviceName, "image_changed", imageChanged, "config_changed", configChanged, "strategy", desiredService.updateStrategy())
+		if desiredService.updateStrategy() == UpdateStartFirst {
+			containerID, err := recreateStartFirst(ctx, cli, projectName, serviceName, &desiredService, actualContainer, opts, logger)
+			if err != nil {
+				logger.Error("Start-first update failed, the old container keeps running", "service_name", serviceName, "error", err)
+				result.fail(fmt.Errorf("service '%s': %w", serviceName, err))
+				return result
 			}
+			result.container = &container.Summary{ID: containerID, State: "running"}
+			return result
+		}
+		logger.Info("Stopping old container", "container_id", actualContainer.ID[:12])
+		if err := cli.ContainerStop(ctx, actualContainer.ID, container.StopOptions{}); err != nil {
+			logger.Error("Failed to stop container", "error", err)
+			result.fail(fmt.Errorf("service '%s': failed to stop container: %w", serviceName, err))
+			return result
+		}
+		if err := cli.ContainerRemove(ctx, actualContainer.ID, container.RemoveOptions{}); err != nil {
+			logger.Error("Failed to remove container", "error", err)
+			result.fail(fmt.Errorf("service '%s': failed to remove container: %w", serviceName, err))
+			return result
+		}
+		containerID, err := createService(ctx, cli, projectName, serviceName, &desiredService, opts, logger)
+		if err != nil {
+			logger.Error("Failed to create new service", "error", err)
+			result.fail(fmt.Errorf("service '%s': %w", serviceName, err))
+			return result
+		}
+		result.container = &container.Summary{ID: containerID, State: "running"}
+		if err := verifyServiceHealth(ctx, cli, serviceName, &desiredService, containerID, opts, logger); err != nil {
+			result.fail(err)
+		}
+		return result
+	}
+
+	if actualContainer.State != "running" && r.oneShot[serviceName] && exitedSuccessfully(ctx, cli, actualContainer) {
+		logger.Info("One-shot service has already completed", "service_name", serviceName)
+	} else if actualContainer.State == "restarting" {
+		// The engine is restarting the container according to its restart policy.
+		logger.Info("Container is being restarted by its restart policy", "service_name", serviceName)
+	} else if actualContainer.State != "running" {
+		plan.add(KindService, serviceName, ActionStart, "container is "+actualContainer.State)
+		if plan.DryRun {
+			logger.Info("Dry run: would start container", "service_name", serviceName, "current_status", actualContainer.State)
+			return result
+		}
+		logger.Warn("Container exists but is not running. Starting...", "service_name", serviceName, "container_id", actualContainer.ID[:12], "current_status", actualContainer.State)
+		if err := cli.ContainerStart(ctx, actualContainer.ID, container.StartOptions{}); err != nil {
+			logger.Error("Failed to start the container", "service_name", serviceName)
+			result.fail(fmt.Errorf("service '%s': failed to start container: %w", serviceName, err))
+		} else {
+			logger.Info("Container started successfully.", "service_name", serviceName)
+		}
+	} else if reason := restartReason(desiredService.DependsOn, actualContainer, r.repair, r.plan); reason != "" {
+		plan.add(KindService, serviceName, ActionRestart, reason)
+		if plan.DryRun {
+			logger.Info("Dry run: would restart container", "service_name", serviceName, "reason", reason)
+			return result
 		}
+		logger.Warn("Restarting container", "service_name", serviceName, "container_id", actualContainer.ID[:12], "reason", reason)
+		if err := cli.ContainerRestart(ctx, actualContainer.ID, container.StopOptions{}); err != nil {
+			logger.Error("Failed to restart the container", "service_name", serviceName, "error", err)
+			result.fail(fmt.Errorf("service '%s': failed to restart container: %w", serviceName, err))
+		} else if err := verifyServiceHealth(ctx, cli, serviceName, &desiredService, actualContainer.ID, opts, logger); err != nil {
+			result.fail(err)
+		}
+	} else {
+		logger.Info("Service is up-to-date and running", "service_name", serviceName)
+	}
+	return result
+}
+
+// verifyServiceHealth waits for a freshly created container to become healthy when its
+// service defines a healthcheck, so a broken deployment is reported as a failure.
+func verifyServiceHealth(ctx context.Context, cli *client.Client, serviceName string, service *Service, containerID string, opts ApplyOptions, logger *slog.Logger) error {
+	if !service.hasHealthCheck() {
+		return nil
+	}
+	if err := waitForHealthCheck(ctx, cli, containerID, healthWaitTimeout(service, opts), logger); err != nil {
+		logger.Error("Service did not become healthy", "service_name", serviceName, "error", err)
+		return fmt.Errorf("service '%s' did not become healthy: %w", serviceName, err)
 	}
 	return nil
 }
 
+// selectServices returns the services of order that are listed in names, keeping their order.
+func selectServices(order, names []string) []string {
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	var selected []string
+	for _, name := range order {
+		if wanted[name] {
+			selected = append(selected, name)
+		}
+	}
+	return selected
+}
+
+// restartReason explains why an up-to-date, running container has to be restarted: it is
+// unhealthy during a repair, or Watcher changed a dependency declared with restart: true.
+// It returns an empty string when no restart is needed.
+func restartReason(deps DependsOn, c container.Summary, repair bool, plan *Plan) string {
+	if repair && isUnhealthy(c) {
+		return "container is unhealthy"
+	}
+	for _, dep := range deps {
+		if dep.Restart && plan.changed(dep.Service) {
+			return fmt.Sprintf("dependency '%s' changed", dep.Service)
+		}
+	}
+	return ""
+}
+
+// isUnhealthy reports whether a container's healthcheck is failing.
+func isUnhealthy(c container.Summary) bool {
+	if c.Health != nil {
+		return c.Health.Status == container.Unhealthy
+	}
+	return strings.Contains(c.Status, "(unhealthy)")
+}
+
+// changeReason describes why a service needs to be re-created.
+func changeReason(imageChanged, configChanged bool) string {
+	switch {
+	case imageChanged && configChanged:
+		return "image and configuration changed"
+	case imageChanged:
+		return "image changed"
+	default:
+		return "configuration changed"
+	}
+}
+
+// containerSpec holds everything needed to create a container for a service.
+type containerSpec struct {
+	Name       string
+	Config     *container.Config
+	HostConfig *container.HostConfig
+	Networking *network.NetworkingConfig
+	ConfigHash string
+}
+
 // createService creates and starts a new Docker container for the specified service
-func createService(ctx context.Context, cli *client.Client, projectName string, serviceName string, service *Service, logger *slog.Logger) error {
+// and returns the ID of the new container. The image must already be present locally.
+func createService(ctx context.Context, cli *client.Client, projectName string, serviceName string, service *Service, opts ApplyOptions, logger *slog.Logger) (string, error) {
 	logger.Info("Creating service", "service_name", serviceName)
 
-	if err := pullImage(ctx, cli, service.Image, logger); err != nil {
-		return fmt.Errorf("failed to pull image %s: %w", service.Image, err)
+	spec, err := buildContainerSpec(projectName, serviceName, service)
+	if err != nil {
+		return "", err
 	}
-	logger.Info("Image pulled successfully.", "image", service.Image)
 
-	exposedPorts, portBindings, err := nat.ParsePortSpecs(service.Ports)
+	resp, err := cli.ContainerCreate(ctx, spec.Config, spec.HostConfig, spec.Networking, nil, spec.Name)
 	if err != nil {
-		return fmt.Errorf("failed to parse port specs: %w", err)
+		return "", fmt.Errorf("failed to create container: %w", err)
+	}
+
+	if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return resp.ID, fmt.Errorf("failed to start container: %w", err)
+	}
+	logger.Info("Successfully created and started service", "service_name", serviceName, "container_id", resp.ID[:12])
+	return resp.ID, nil
+}
+
+// buildContainerSpec translates a compose service into the Docker API configuration
+// used to create its container, and stamps the result with a hash of that configuration.
+func buildContainerSpec(projectName string, serviceName string, service *Service) (*containerSpec, error) {
+	exposedPorts, portBindings, err := nat.ParsePortSpecs([]string(service.Ports))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse port specs: %w", err)
 	}
 
 	// The keys in this map must be the FULL network names.
@@ -143,46 +488,33 @@ func createService(ctx context.Context, cli *client.Client, projectName string,
 		containerName = serviceName
 	}
 
-	// We need to process the Binds to prefix named volumes.
-	var processedBinds []string
-	for _, v := range service.Volumes {
-		parts := strings.SplitN(v, ":", 2)
-		if len(parts) == 2 {
-			source := parts[0]
-			// Check if it's a named volume (and not a host path bind mount)
-			if !strings.HasPrefix(source, "/") && !strings.HasPrefix(source, ".") {
-				// It's a named volume, so prefix the source with the project name.
-				prefixedSource := fmt.Sprintf("%s_%s", projectName, source)
-				processedBinds = append(processedBinds, fmt.Sprintf("%s:%s", prefixedSource, parts[1]))
-			} else {
-				// It's a bind mount (e.g., /path/on/host:/path/in/container), so use it as-is.
-				processedBinds = append(processedBinds, v)
-			}
-		} else {
-			logger.Warn("Skipping malformed volume definition", "volume_string", v)
-		}
+	binds, mounts, err := volumeMounts(projectName, service.Volumes)
+	if err != nil {
+		return nil, err
 	}
 
 	var healthConfig *container.HealthConfig
-	if service.HealthCheck != nil && len(service.HealthCheck.Test) > 0 {
+	if service.HealthCheck != nil && service.HealthCheck.disabled() {
+		healthConfig = &container.HealthConfig{Test: []string{healthTestNone}}
+	} else if service.HealthCheck != nil && len(service.HealthCheck.Test) > 0 {
 		var interval, timeout, startPeriod time.Duration
 		var err error
 		if service.HealthCheck.Interval != "" {
 			interval, err = time.ParseDuration(service.HealthCheck.Interval)
 			if err != nil {
-				return fmt.Errorf("invalid healthcheck interval format '%s': %w", service.HealthCheck.Interval, err)
+				return nil, fmt.Errorf("invalid healthcheck interval format '%s': %w", service.HealthCheck.Interval, err)
 			}
 		}
 		if service.HealthCheck.Timeout != "" {
 			timeout, err = time.ParseDuration(service.HealthCheck.Timeout)
 			if err != nil {
-				return fmt.Errorf("invalid healthcheck timeout format '%s': %w", service.HealthCheck.Timeout, err)
+				return nil, fmt.Errorf("invalid healthcheck timeout format '%s': %w", service.HealthCheck.Timeout, err)
 			}
 		}
 		if service.HealthCheck.StartPeriod != "" {
 			startPeriod, err = time.ParseDuration(service.HealthCheck.StartPeriod)
 			if err != nil {
-				return fmt.Errorf("invalid healthcheck start_period format '%s': %w", service.HealthCheck.StartPeriod, err)
+				return nil, fmt.Errorf("invalid healthcheck start_period format '%s': %w", service.HealthCheck.StartPeriod, err)
 			}
 		}
 
@@ -195,50 +527,152 @@ func createService(ctx context.Context, cli *client.Client, projectName string,
 		}
 	}
 
-	hostConfig := &container.HostConfig{
-		PortBindings: portBindings,
-		Binds:        processedBinds, // Use the processed list of binds
+	labels := make(map[string]string, len(service.Labels)+3)
+	for k, v := range service.Labels {
+		labels[k] = v
 	}
+	labels["com.docker.compose.project"] = projectName
+	labels["com.docker.compose.service"] = serviceName
 
-	resp, err := cli.ContainerCreate(ctx, &container.Config{
-		Image:        service.Image,
-		Env:          service.Environment,
-		Cmd:          service.Command,
-		ExposedPorts: exposedPorts,
-		Healthcheck:  healthConfig,
-		Labels: map[string]string{
-			"com.docker.compose.project": projectName,
-			"com.docker.compose.service": serviceName,
+	spec := &containerSpec{
+		Name: containerName,
+		Config: &container.Config{
+			Image:        service.Image,
+			Env:          []string(service.Environment),
+			Cmd:          []string(service.Command),
+			ExposedPorts: exposedPorts,
+			Healthcheck:  healthConfig,
+			Labels:       labels,
+		},
+		HostConfig: &container.HostConfig{
+			PortBindings: portBindings,
+			Binds:        binds,
+			Mounts:       mounts,
+		},
+		Networking: &network.NetworkingConfig{
+			EndpointsConfig: endpointsConfig,
 		},
-	}, hostConfig, &network.NetworkingConfig{
-		EndpointsConfig: endpointsConfig,
-	}, nil, containerName)
+	}
+	if err := applyResources(service, spec.HostConfig); err != nil {
+		return nil, err
+	}
+	if spec.HostConfig.RestartPolicy, err = restartPolicy(service); err != nil {
+		return nil, err
+	}
+	if err := applyRuntimeOptions(service, spec.Config, spec.HostConfig); err != nil {
+		return nil, err
+	}
+
+	hash, err := hashService(projectName, serviceName, containerName, service)
 	if err != nil {
-		return fmt.Errorf("failed to create container: %w", err)
+		return nil, err
 	}
+	spec.ConfigHash = hash
+	spec.Config.Labels[configHashLabel] = hash
+	return spec, nil
+}
 
-	if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
-		return fmt.Errorf("failed to start container: %w", err)
+// configHashVersion prefixes every config hash. Bump it when the hashed view of a service
+// changes meaning; every managed container is then re-created once.
+const configHashVersion = "v1"
+
+// hashService returns a hash of the configuration a service's container is created from. It
+// hashes Watcher's own view of the compose service rather than the Docker API types, so a
+// Docker client upgrade does not change it. Empty values are dropped, so a new option only
+// changes the hash of services that set it. depends_on, pull_policy, env_file (already merged
+// into the environment) and x-watcher only affect how Watcher deploys the service and are left out.
+func hashService(projectName, serviceName, containerName string, service *Service) (string, error) {
+	view := *service
+	view.ContainerName = containerName
+	view.DependsOn = nil
+	view.PullPolicy = ""
+	view.EnvFile = nil
+	view.XWatcher = nil
+
+	data, err := json.Marshal(view)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash service config: %w", err)
 	}
-	logger.Info("Successfully created and started service", "service_name", serviceName, "container_id", resp.ID[:12])
-	return nil
+	var tree any
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return "", fmt.Errorf("failed to hash service config: %w", err)
+	}
+	// encoding/json sorts map keys, so equal configurations always produce the same hash.
+	data, err = json.Marshal(map[string]any{"project": projectName, "service": serviceName, "config": pruneEmpty(tree)})
+	if err != nil {
+		return "", fmt.Errorf("failed to hash service config: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return configHashVersion + ":" + hex.EncodeToString(sum[:]), nil
 }
 
-// pullImage pulls the specified Docker image from the registry
-func pullImage(ctx context.Context, cli *client.Client, imageName string, logger *slog.Logger) error {
-	out, err := cli.ImagePull(ctx, imageName, image.PullOptions{})
+// pruneEmpty removes null, false, zero, empty string and empty collection values from the
+// objects in a decoded JSON value. List elements are kept, as their position is significant.
+func pruneEmpty(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			if child = pruneEmpty(child); isEmpty(child) {
+				delete(v, key)
+			} else {
+				v[key] = child
+			}
+		}
+	case []any:
+		for i, child := range v {
+			v[i] = pruneEmpty(child)
+		}
+	}
+	return value
+}
+
+func isEmpty(value any) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case float64:
+		return v == 0
+	case string:
+		return v == ""
+	case map[string]any:
+		return len(v) == 0
+	case []any:
+		return len(v) == 0
+	}
+	return false
+}
+
+// pullImage pulls the specified Docker image from the registry, authenticating with the
+// credentials registryAuth holds for the image's registry.
+func pullImage(ctx context.Context, cli *client.Client, imageName string, registryAuth *RegistryAuth, logger *slog.Logger) (err error) {
+	start := time.Now()
+	defer func() {
+		metrics.ImagePullDuration.WithLabelValues(metrics.Outcome(err)).Observe(time.Since(start).Seconds())
+	}()
+
+	encodedAuth, err := registryAuth.EncodedAuth(imageName)
+	if err != nil {
+		return fmt.Errorf("could not resolve registry credentials: %w", err)
+	}
+	out, err := cli.ImagePull(ctx, imageName, image.PullOptions{RegistryAuth: encodedAuth})
 	if err != nil {
 		return err
 	}
 	defer out.Close()
-	io.Copy(io.Discard, out)
-	return nil
+	// The pull only completes once the progress stream has been fully read.
+	_, err = io.Copy(io.Discard, out)
+	return err
 }
 
-func waitForHealthCheck(ctx context.Context, cli *client.Client, containerID string, logger *slog.Logger) error {
-	logger.Info("Waiting for container to be healthy", "container_id", containerID[:12])
+func waitForHealthCheck(ctx context.Context, cli *client.Client, containerID string, timeout time.Duration, logger *slog.Logger) error {
+	logger.Info("Waiting for container to be healthy", "container_id", containerID[:12], "timeout", timeout)
 
-	for i := 0; i < 60; i++ {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(healthPollInterval)
+	defer ticker.Stop()
+	for {
 		inspect, err := cli.ContainerInspect(ctx, containerID)
 		if err != nil {
 			return fmt.Errorf("failed to inspect container %s: %w", containerID, err)
@@ -255,7 +689,40 @@ func waitForHealthCheck(ctx context.Context, cli *client.Client, containerID str
 
 			}
 		}
-		time.Sleep(5 * time.Second)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			metrics.HealthWaitTimeouts.Inc()
+			return fmt.Errorf("timed out after %s waiting for container %s to become healthy", timeout, containerID)
+		case <-ticker.C:
+		}
+	}
+}
+
+// healthWaitTimeout returns how long to wait for a service to become healthy: its x-watcher
+// health_timeout, else opts.HealthTimeout, else the time its healthcheck needs to report the
+// container unhealthy (interval × retries + start_period, with Docker's defaults).
+func healthWaitTimeout(service *Service, opts ApplyOptions) time.Duration {
+	if service.XWatcher != nil && service.XWatcher.HealthTimeout != "" {
+		if d, err := time.ParseDuration(service.XWatcher.HealthTimeout); err == nil {
+			return d
+		}
+	}
+	if opts.HealthTimeout > 0 {
+		return opts.HealthTimeout
+	}
+	interval, retries, startPeriod := defaultHealthInterval, defaultHealthRetries, time.Duration(0)
+	if hc := service.HealthCheck; hc != nil {
+		if d, err := time.ParseDuration(hc.Interval); err == nil && d > 0 {
+			interval = d
+		}
+		if hc.Retries > 0 {
+			retries = hc.Retries
+		}
+		if d, err := time.ParseDuration(hc.StartPeriod); err == nil {
+			startPeriod = d
+		}
 	}
-	return fmt.Errorf("timed out waiting for container %s to become healthy", containerID)
+	return interval*time.Duration(retries) + startPeriod
 }
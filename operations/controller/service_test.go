@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHashService(t *testing.T) {
+	base := func() *Service {
+		return &Service{
+			Image:       "nginx:1.27",
+			Environment: Environment{"A=1", "B=2"},
+			Labels:      Labels{"team": "web", "tier": "front"},
+			Ports:       Ports{"80:80"},
+			Volumes:     []ServiceVolume{{Short: "data:/data"}},
+			Networks:    []string{"front"},
+			HealthCheck: &HealthCheck{Test: HealthCheckTest{"CMD", "true"}, Interval: "5s"},
+		}
+	}
+	hash := func(t *testing.T, s *Service) string {
+		t.Helper()
+		h, err := hashService("demo", "web", "web", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	want := hash(t, base())
+	if !strings.HasPrefix(want, configHashVersion+":") {
+		t.Errorf("hash %q does not start with the version %q", want, configHashVersion)
+	}
+
+	tests := []struct {
+		name    string
+		change  func(s *Service)
+		changed bool
+	}{
+		{name: "no change", change: func(s *Service) {}},
+		{name: "explicit empty values", change: func(s *Service) {
+			s.Privileged = false
+			s.CapAdd = []string{}
+			s.Sysctls = Sysctls{}
+			s.Logging = nil
+		}},
+		{name: "depends_on", change: func(s *Service) { s.DependsOn = DependsOn{{Service: "db", Condition: ConditionHealthy}} }},
+		{name: "pull_policy", change: func(s *Service) { s.PullPolicy = PullAlways }},
+		{name: "env_file", change: func(s *Service) { s.EnvFile = EnvFiles{{Path: "app.env"}} }},
+		{name: "x-watcher", change: func(s *Service) { s.XWatcher = &WatcherExtension{UpdateStrategy: UpdateStartFirst} }},
+
+		{name: "image", change: func(s *Service) { s.Image = "nginx:1.28" }, changed: true},
+		{name: "environment", change: func(s *Service) { s.Environment = append(s.Environment, "C=3") }, changed: true},
+		{name: "label", change: func(s *Service) { s.Labels["tier"] = "back" }, changed: true},
+		{name: "short syntax volume", change: func(s *Service) { s.Volumes[0].Short = "other:/data" }, changed: true},
+		{name: "healthcheck", change: func(s *Service) { s.HealthCheck.Retries = 5 }, changed: true},
+		{name: "privileged", change: func(s *Service) { s.Privileged = true }, changed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := base()
+			tt.change(s)
+			if got := hash(t, s); (got != want) != tt.changed {
+				t.Errorf("hash changed = %v, want %v", got != want, tt.changed)
+			}
+		})
+	}
+}
+
+func TestHashServiceUsesResolvedNames(t *testing.T) {
+	s := &Service{Image: "nginx"}
+	a, _ := hashService("demo", "web", "web", s)
+	for _, other := range [][3]string{{"other", "web", "web"}, {"demo", "api", "web"}, {"demo", "web", "web-1"}} {
+		if b, _ := hashService(other[0], other[1], other[2], s); a == b {
+			t.Errorf("hashService(%q, %q, %q) equals the hash for demo/web/web", other[0], other[1], other[2])
+		}
+	}
+}
+
+func TestHashServiceIsStable(t *testing.T) {
+	// A changed hash re-creates every managed container, so it must only change on purpose,
+	// together with configHashVersion.
+	s := &Service{Image: "nginx:1.27", Ports: Ports{"80:80"}, Restart: "always"}
+	got, err := hashService("demo", "web", "web", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "v1:859893b494d94747cb097aa9ef46a9dff06c4ab578e5bcb8e6fd4baf1825b261"
+	if got != want {
+		t.Errorf("hashService() = %q, want %q", got, want)
+	}
+}
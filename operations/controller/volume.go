@@ -4,13 +4,15 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
 
 	"github.com/moby/moby/api/types/filters"
 	"github.com/moby/moby/api/types/volume"
 	"github.com/moby/moby/client"
 )
 
-func ReconcileVolumes(ctx context.Context, cli *client.Client, projectName string, volumes map[string]Volume, logger *slog.Logger) {
+func ReconcileVolumes(ctx context.Context, cli *client.Client, projectName string, volumes map[string]Volume, plan *Plan, logger *slog.Logger) {
 	logger.Info("Reconciling volumes...")
 
 	volFilters := filters.NewArgs(filters.Arg("label", "com.docker.compose.project="+projectName))
@@ -25,7 +27,7 @@ func ReconcileVolumes(ctx context.Context, cli *client.Client, projectName strin
 	actualVolumeMap := make(map[string]struct{})
 
 	for _, vol := range actualVolumes.Volumes {
-		actualVolumeMap[vol.Labels["com.docker.compose.project"]] = struct{}{}
+		actualVolumeMap[vol.Labels["com.docker.compose.volume"]] = struct{}{}
 	}
 
 	if len(volumes) == 0 {
@@ -33,7 +35,8 @@ func ReconcileVolumes(ctx context.Context, cli *client.Client, projectName strin
 		return
 	}
 
-	for volumeName, vol := range volumes {
+	for _, volumeName := range slices.Sorted(maps.Keys(volumes)) {
+		vol := volumes[volumeName]
 		if vol.External {
 			logger.Info("Skipping creation for external volume", "volume_name", volumeName)
 			continue
@@ -44,6 +47,11 @@ func ReconcileVolumes(ctx context.Context, cli *client.Client, projectName strin
 		}
 
 		fullVolumeName := fmt.Sprintf("%s_%s", projectName, volumeName)
+		plan.add(KindVolume, volumeName, ActionCreate, "not found")
+		if plan.DryRun {
+			logger.Info("Dry run: would create volume", "full_volume_name", fullVolumeName)
+			continue
+		}
 
 		_, err := cli.VolumeCreate(ctx, volume.CreateOptions{
 			Name:   fullVolumeName,
@@ -62,6 +70,11 @@ func ReconcileVolumes(ctx context.Context, cli *client.Client, projectName strin
 	for _, actualVol := range actualVolumes.Volumes {
 		volumeName := actualVol.Labels["com.docker.compose.volume"]
 		if _, existsInDesired := volumes[volumeName]; !existsInDesired {
+			plan.add(KindVolume, volumeName, ActionRemove, "orphaned")
+			if plan.DryRun {
+				logger.Info("Dry run: would remove orphaned volume", "volume_name", actualVol.Name)
+				continue
+			}
 			logger.Info("Found orphaned volume. Removing...", "volume_name", actualVol.Name)
 
 			if err := cli.VolumeRemove(ctx, actualVol.Name, true); err != nil {
@@ -0,0 +1,217 @@
+package operations
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/moby/moby/api/types/events"
+	"github.com/moby/moby/api/types/filters"
+	"github.com/moby/moby/client"
+	"github.com/sithukyaw666/watcher/model"
+)
+
+const (
+	// repairBackoffBase is the delay before the second repair of a service in a row; every
+	// further repair doubles it up to repairBackoffMax.
+	repairBackoffBase = 5 * time.Second
+	repairBackoffMax  = 5 * time.Minute
+	// repairQuietPeriod resets the backoff once a service has not needed a repair for that long.
+	repairQuietPeriod = 10 * time.Minute
+	// At most repairBurst repairs of one service are started within repairWindow.
+	repairBurst  = 5
+	repairWindow = 10 * time.Minute
+
+	eventsReconnectMax = 30 * time.Second
+)
+
+// RunTracker records when Watcher itself is reconciling a project, so the container events
+// caused by its own stops, removals and re-creations are not taken for failures. It is safe
+// for concurrent use, and a nil RunTracker tracks nothing.
+type RunTracker struct {
+	mu         sync.Mutex
+	running    bool
+	start, end time.Time
+}
+
+// Begin marks the start of a run.
+func (t *RunTracker) Begin() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running, t.start = true, time.Now()
+}
+
+// End marks the end of the run started last.
+func (t *RunTracker) End() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running, t.end = false, time.Now()
+}
+
+// during reports whether at falls within the current or the last run.
+func (t *RunTracker) during(at time.Time) bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if at.Before(t.start) {
+		return false
+	}
+	return t.running || !at.After(t.end)
+}
+
+// WatchEvents follows the Docker events of the project's containers and sends the name of a
+// service to repairs when one of its containers dies, is destroyed or becomes unhealthy.
+// Events that happen while runs tracks a reconciliation are ignored, as they are usually
+// caused by it. Repairs of the same service are coalesced and backed off so a crash-looping
+// container cannot keep Watcher busy. It returns when ctx is cancelled.
+func WatchEvents(ctx context.Context, cli *client.Client, config model.Config, runs *RunTracker, repairs chan<- string, logger *slog.Logger) {
+	limiter := newRepairLimiter(repairs)
+	options := events.ListOptions{Filters: filters.NewArgs(
+		filters.Arg("type", string(events.ContainerEventType)),
+		filters.Arg("label", "com.docker.compose.project="+config.ProjectName),
+		filters.Arg("event", string(events.ActionDie)),
+		filters.Arg("event", string(events.ActionDestroy)),
+		filters.Arg("event", string(events.ActionHealthStatus)),
+	)}
+
+	reconnectDelay := time.Second
+	for {
+		streamCtx, cancel := context.WithCancel(ctx)
+		messages, errs := cli.Events(streamCtx, options)
+		logger.Info("Watching Docker events for self-healing")
+		err := consumeEvents(ctx, messages, errs, limiter, runs, func() { reconnectDelay = time.Second }, logger)
+		cancel()
+		if ctx.Err() != nil {
+			limiter.stop()
+			return
+		}
+		logger.Warn("Docker events stream interrupted, reconnecting", "error", err, "retry_in", reconnectDelay)
+		select {
+		case <-ctx.Done():
+			limiter.stop()
+			return
+		case <-time.After(reconnectDelay):
+		}
+		reconnectDelay = min(reconnectDelay*2, eventsReconnectMax)
+	}
+}
+
+// consumeEvents handles messages until the stream fails, calling connected on the first one.
+func consumeEvents(ctx context.Context, messages <-chan events.Message, errs <-chan error, limiter *repairLimiter, runs *RunTracker, connected func(), logger *slog.Logger) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errs:
+			return err
+		case msg := <-messages:
+			connected()
+			service := msg.Actor.Attributes["com.docker.compose.service"]
+			if service == "" || !needsRepair(msg) {
+				continue
+			}
+			if runs.during(time.Unix(0, msg.TimeNano)) {
+				logger.Debug("Ignoring container event during reconciliation", "service_name", service, "event", msg.Action)
+				continue
+			}
+			logger.Info("Managed container needs repair", "service_name", service, "event", msg.Action, "container_id", shortHash(msg.Actor.ID))
+			limiter.request(service, logger)
+		}
+	}
+}
+
+// needsRepair reports whether an event leaves a service without a healthy container.
+// Containers that exit successfully have finished their work and are left alone.
+func needsRepair(msg events.Message) bool {
+	switch msg.Action {
+	case events.ActionDie:
+		return msg.Actor.Attributes["exitCode"] != "0"
+	case events.ActionDestroy, events.ActionHealthStatusUnhealthy:
+		return true
+	}
+	return false
+}
+
+// repairLimiter schedules repairs per service with exponential backoff and a rate limit.
+type repairLimiter struct {
+	mu       sync.Mutex
+	repairs  chan<- string
+	services map[string]*repairSchedule
+	done     chan struct{}
+}
+
+type repairSchedule struct {
+	pending bool
+	delay   time.Duration
+	last    time.Time
+	starts  []time.Time
+}
+
+func newRepairLimiter(repairs chan<- string) *repairLimiter {
+	return &repairLimiter{repairs: repairs, services: make(map[string]*repairSchedule), done: make(chan struct{})}
+}
+
+// request schedules a repair of service unless one is already pending.
+func (l *repairLimiter) request(service string, logger *slog.Logger) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s, ok := l.services[service]
+	if !ok {
+		s = &repairSchedule{}
+		l.services[service] = s
+	}
+	if s.pending {
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(s.last) > repairQuietPeriod {
+		s.delay = 0
+	}
+	recent := s.starts[:0]
+	for _, t := range s.starts {
+		if now.Sub(t) < repairWindow {
+			recent = append(recent, t)
+		}
+	}
+	s.starts = recent
+
+	wait := s.delay
+	if len(s.starts) >= repairBurst {
+		wait = max(wait, s.starts[0].Add(repairWindow).Sub(now))
+	}
+	if wait > 0 {
+		logger.Warn("Delaying repair of frequently failing service", "service_name", service, "delay", wait.Round(time.Second))
+	}
+
+	s.pending = true
+	time.AfterFunc(wait, func() {
+		select {
+		case l.repairs <- service:
+		case <-l.done:
+			return
+		}
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		now := time.Now()
+		s.pending = false
+		s.last = now
+		s.starts = append(s.starts, now)
+		s.delay = min(max(s.delay*2, repairBackoffBase), repairBackoffMax)
+	})
+}
+
+// stop releases scheduled repairs that have not been delivered.
+func (l *repairLimiter) stop() {
+	close(l.done)
+}
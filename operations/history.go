@@ -0,0 +1,207 @@
+package operations
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/sithukyaw666/watcher/model"
+	"github.com/sithukyaw666/watcher/operations/controller"
+)
+
+const historyFileName = "history.jsonl"
+
+// defaultHistoryLimit is the number of history entries kept when historyLimit is not set.
+const defaultHistoryLimit = 1000
+
+// HistoryEntry records one reconciliation cycle.
+type HistoryEntry struct {
+	Project         string              `json:"project"`
+	StartedAt       time.Time           `json:"started_at"`
+	Trigger         string              `json:"trigger,omitempty"`
+	DurationSeconds float64             `json:"duration_seconds"`
+	OldCommit       string              `json:"old_commit,omitempty"`
+	NewCommit       string              `json:"new_commit,omitempty"`
+	Author          string              `json:"author,omitempty"`
+	Message         string              `json:"message,omitempty"`
+	DryRun          bool                `json:"dry_run,omitempty"`
+	Actions         []controller.Action `json:"actions"`
+	RolledBackTo    string              `json:"rolled_back_to,omitempty"`
+	Errors          []string            `json:"errors,omitempty"`
+}
+
+// Eventful reports whether the cycle changed anything or failed. Cycles that only confirmed
+// the current state are not worth keeping.
+func (e *HistoryEntry) Eventful() bool {
+	return e.OldCommit != e.NewCommit || len(e.Actions) > 0 || len(e.Errors) > 0 || e.RolledBackTo != ""
+}
+
+// AddError records an error that occurred during the cycle.
+func (e *HistoryEntry) AddError(err error) {
+	e.Errors = append(e.Errors, err.Error())
+}
+
+// HistoryPath returns the file the project's deployment history is stored in.
+func HistoryPath(config model.Config) string {
+	return filepath.Join(StateDir(config), historyFileName)
+}
+
+// AppendHistory appends entry to the project's history, keeping at most historyLimit entries.
+func AppendHistory(config model.Config, entry HistoryEntry) error {
+	if err := os.MkdirAll(StateDir(config), 0o755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode history entry: %w", err)
+	}
+
+	path := HistoryPath(config)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open history file: %w", err)
+	}
+	_, writeErr := f.Write(append(line, '\n'))
+	if err := f.Close(); writeErr == nil {
+		writeErr = err
+	}
+	if writeErr != nil {
+		return fmt.Errorf("failed to write history entry: %w", writeErr)
+	}
+	return trimHistory(path, historyLimit(config))
+}
+
+func historyLimit(config model.Config) int {
+	if config.HistoryLimit > 0 {
+		return config.HistoryLimit
+	}
+	return defaultHistoryLimit
+}
+
+// trimHistory rewrites the history file with only its last limit lines.
+func trimHistory(path string, limit int) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read history file: %w", err)
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) <= limit {
+		return nil
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, bytes.Join(lines[len(lines)-limit:], nil), 0o644); err != nil {
+		return fmt.Errorf("failed to trim history file: %w", err)
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// ReadHistory returns up to limit of the project's most recent history entries, newest first.
+// A limit of zero or less returns every entry.
+func ReadHistory(config model.Config, limit int) ([]HistoryEntry, error) {
+	f, err := os.Open(HistoryPath(config))
+	if errors.Is(err, os.ErrNotExist) {
+		return []HistoryEntry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file: %w", err)
+	}
+	defer f.Close()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A partially written last line must not hide the rest of the history.
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history file: %w", err)
+	}
+
+	newestFirst := make([]HistoryEntry, 0, len(entries))
+	for i := len(entries) - 1; i >= 0 && (limit <= 0 || len(newestFirst) < limit); i-- {
+		newestFirst = append(newestFirst, entries[i])
+	}
+	return newestFirst, nil
+}
+
+// CommitInfo returns the author and the first line of the message of a commit.
+func CommitInfo(config model.Config, hash plumbing.Hash) (author, message string, err error) {
+	repo, err := git.PlainOpen(config.DeploymentDir)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to open repository: %w", err)
+	}
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read commit %s: %w", hash, err)
+	}
+	message, _, _ = strings.Cut(strings.TrimSpace(commit.Message), "\n")
+	return fmt.Sprintf("%s <%s>", commit.Author.Name, commit.Author.Email), message, nil
+}
+
+// WriteHistoryText writes a human readable listing of history entries.
+func WriteHistoryText(w io.Writer, entries []HistoryEntry) error {
+	if len(entries) == 0 {
+		_, err := fmt.Fprintln(w, "No deployment history recorded.")
+		return err
+	}
+	for _, e := range entries {
+		status := "OK"
+		switch {
+		case e.RolledBackTo != "":
+			status = "ROLLED BACK to " + shortHash(e.RolledBackTo)
+		case len(e.Errors) > 0:
+			status = "FAILED"
+		}
+		if e.DryRun {
+			status += " (dry run)"
+		}
+		if e.Trigger != "" {
+			status += " [" + e.Trigger + "]"
+		}
+		commit := shortHash(e.NewCommit)
+		if e.OldCommit != e.NewCommit {
+			commit = shortHash(e.OldCommit) + " -> " + commit
+		}
+		if _, err := fmt.Fprintf(w, "%s  %s  %s  %.1fs  %s\n", e.StartedAt.Format(time.RFC3339), e.Project, commit, e.DurationSeconds, status); err != nil {
+			return err
+		}
+		if e.Message != "" {
+			fmt.Fprintf(w, "    %q by %s\n", e.Message, e.Author)
+		}
+		for _, a := range e.Actions {
+			line := fmt.Sprintf("    %s %s %s", a.Type, a.Kind, a.Name)
+			if a.Reason != "" {
+				line += " (" + a.Reason + ")"
+			}
+			fmt.Fprintln(w, line)
+		}
+		for _, msg := range e.Errors {
+			fmt.Fprintf(w, "    error: %s\n", msg)
+		}
+	}
+	return nil
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
@@ -0,0 +1,87 @@
+package operations
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/containerd/errdefs"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/image"
+	"github.com/moby/moby/client"
+	"github.com/sithukyaw666/watcher/model"
+	"github.com/sithukyaw666/watcher/operations/controller"
+)
+
+// PruneImages records the image each of the project's services runs in state and removes
+// images that services used before, keeping the config.ImageRetention most recent images of
+// every service. Images still referenced by any container, of this project or not, are kept.
+// It returns a remove action for every deleted image.
+func PruneImages(ctx context.Context, cli *client.Client, config model.Config, state *model.DeploymentState, logger *slog.Logger) ([]controller.Action, error) {
+	containers, err := cli.ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	inUse := make(map[string]bool)
+	current := make(map[string]string)
+	for _, c := range containers {
+		inUse[c.ImageID] = true
+		if c.Labels["com.docker.compose.project"] == config.ProjectName {
+			if service := c.Labels["com.docker.compose.service"]; service != "" {
+				current[service] = c.ImageID
+			}
+		}
+	}
+
+	if state.Images == nil {
+		state.Images = make(map[string][]string)
+	}
+	for service, imageID := range current {
+		history := slices.DeleteFunc(state.Images[service], func(id string) bool { return id == imageID })
+		state.Images[service] = append([]string{imageID}, history...)
+	}
+
+	// Images are only removed once no service keeps them, as services may share an image.
+	kept := make(map[string]bool)
+	var candidates []string
+	for _, service := range slices.Sorted(maps.Keys(state.Images)) {
+		history := state.Images[service]
+		keep := min(len(history), config.ImageRetention)
+		for _, id := range history[:keep] {
+			kept[id] = true
+		}
+		candidates = append(candidates, history[keep:]...)
+		state.Images[service] = history[:keep]
+	}
+
+	actions := []controller.Action{}
+	var errs []string
+	for _, id := range candidates {
+		if kept[id] || inUse[id] {
+			continue
+		}
+		kept[id] = true // Skip duplicates.
+		name := shortHash(strings.TrimPrefix(id, "sha256:"))
+		if inspect, err := cli.ImageInspect(ctx, id); err == nil && len(inspect.RepoTags) > 0 {
+			name = inspect.RepoTags[0] + " (" + name + ")"
+		}
+		logger.Info("Removing unused image", "image", name)
+		// Forced so images with several tags are removed too; images used by containers were skipped above.
+		if _, err := cli.ImageRemove(ctx, id, image.RemoveOptions{Force: true, PruneChildren: true}); err != nil {
+			if errdefs.IsNotFound(err) {
+				continue
+			}
+			logger.Warn("Failed to remove image", "image", name, "error", err)
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		actions = append(actions, controller.Action{Kind: controller.KindImage, Name: name, Type: controller.ActionRemove, Reason: "replaced"})
+	}
+	if len(errs) > 0 {
+		return actions, fmt.Errorf("failed to remove images: %s", strings.Join(errs, "; "))
+	}
+	return actions, nil
+}
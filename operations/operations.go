@@ -6,37 +6,39 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"github.com/moby/moby/client"
+	"github.com/sithukyaw666/watcher/metrics"
 	"github.com/sithukyaw666/watcher/model"
 	"github.com/sithukyaw666/watcher/operations/controller"
 )
 
-func CloneOrFetchRepo(config model.Config, logger *slog.Logger) (*model.RepoUpdate, error) {
+// CloneOrFetchRepo clones the repository or fetches and checks out the latest commit of the
+// target branch. Commits recorded as bad in state are never checked out.
+func CloneOrFetchRepo(config model.Config, state *model.DeploymentState, logger *slog.Logger) (*model.RepoUpdate, error) {
+	start := time.Now()
+	update, err := cloneOrFetchRepo(config, state, logger)
+	metrics.GitFetchDuration.WithLabelValues(config.ProjectName).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.GitFetchFailures.WithLabelValues(config.ProjectName).Inc()
+		return nil, err
+	}
+	metrics.LastSyncTimestamp.WithLabelValues(config.ProjectName).SetToCurrentTime()
+	if head, err := HeadCommit(config); err == nil {
+		metrics.SetSyncedCommit(config.ProjectName, head.String())
+	}
+	return update, nil
+}
 
-	var auth ssh.AuthMethod
-	var err error
+func cloneOrFetchRepo(config model.Config, state *model.DeploymentState, logger *slog.Logger) (*model.RepoUpdate, error) {
 
-	if os.Getenv("SSH_AUTH_SOCK") != "" {
-		logger.Info("SSH Agent detected, attempting authentication.")
-		auth, err = ssh.NewSSHAgentAuth("git")
-		if err != nil {
-			logger.Warn("SSH agent auth failed, will attemp key file.", "error", err)
-		}
-	}
-	// Create the SSH authentication method with the private key
-	if auth == nil {
-		if config.SSHKeyPath == "" {
-			return nil, fmt.Errorf("no SSH agent found and sshKeyPath is not configured")
-		}
-		logger.Info("Using SSH key file for authentication.", "path", config.SSHKeyPath)
-		auth, err = ssh.NewPublicKeysFromFile("git", config.SSHKeyPath, "")
-		if err != nil {
-			return nil, fmt.Errorf("could not create SSH authentication: %w", err)
-		}
+	auth, err := resolveAuth(config, logger)
+	if err != nil {
+		return nil, err
 	}
 
 	repo, err := git.PlainOpen(config.DeploymentDir)
@@ -97,60 +99,227 @@ func CloneOrFetchRepo(config model.Config, logger *slog.Logger) (*model.RepoUpda
 		logger.Info("Repository is already up-to-date")
 		return nil, nil
 	}
+	if IsBadCommit(state, newHash.String()) {
+		logger.Warn("Latest commit previously failed to deploy and was rolled back. Skipping it until a new commit is pushed.", "bad_hash", newHash, "current_hash", oldHash)
+		return nil, nil
+	}
 	logger.Info("Updating repository", "old_hash", oldHash, "new_hash", newHash)
 
+	if err := resetWorktree(repo, config.TargetBranch, newHash); err != nil {
+		return nil, err
+	}
+	logger.Info("Update successful.")
+	return &model.RepoUpdate{
+		OldHash: oldHash,
+		NewHash: newHash,
+	}, nil
+}
+
+// resetWorktree checks out the target branch and hard resets it to hash.
+func resetWorktree(repo *git.Repository, targetBranch string, hash plumbing.Hash) error {
 	w, err := repo.Worktree()
 	if err != nil {
-		return nil, fmt.Errorf("Failed to get worktree: %w", err)
+		return fmt.Errorf("Failed to get worktree: %w", err)
 	}
-	branchRef := plumbing.NewBranchReferenceName(config.TargetBranch)
+	branchRef := plumbing.NewBranchReferenceName(targetBranch)
 	err = w.Checkout(&git.CheckoutOptions{
 		Branch: branchRef,
 	})
 	if err == git.ErrBranchNotFound {
 		err = w.Checkout(&git.CheckoutOptions{
-			Hash:   newHash,
+			Hash:   hash,
 			Branch: branchRef,
 			Create: true,
 		})
 	}
 	if err != nil {
-		return nil, fmt.Errorf("Failed to checkout branch: %w", err)
+		return fmt.Errorf("Failed to checkout branch: %w", err)
 	}
 	err = w.Reset(&git.ResetOptions{
-		Commit: newHash,
+		Commit: hash,
 		Mode:   git.HardReset,
 	})
 
 	if err != nil {
-		return nil, fmt.Errorf("Failed to reset the worktree: %w", err)
+		return fmt.Errorf("Failed to reset the worktree: %w", err)
 	}
-	logger.Info("Update successful.")
-	return &model.RepoUpdate{
-		OldHash: oldHash,
-		NewHash: newHash,
-	}, nil
+	return nil
+}
+
+// HeadCommit returns the commit currently checked out in the deployment directory.
+func HeadCommit(config model.Config) (plumbing.Hash, error) {
+	repo, err := git.PlainOpen(config.DeploymentDir)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to open repository: %w", err)
+	}
+	headRef, err := repo.Head()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to get HEAD: %w", err)
+	}
+	return headRef.Hash(), nil
+}
+
+// Rollback marks failedHash as bad, resets the deployment worktree to the last known good
+// commit and re-applies it. The last good commit comes from the persisted state, falling
+// back to the commit deployed before this cycle's update. It returns the commit rolled back
+// to and the plan of the re-applied deployment.
+func Rollback(ctx context.Context, cli *client.Client, config model.Config, state *model.DeploymentState, failedHash plumbing.Hash, update *model.RepoUpdate, logger *slog.Logger) (plumbing.Hash, *controller.Plan, error) {
+	target := plumbing.NewHash(state.LastGoodHash)
+	if target.IsZero() && update != nil {
+		target = update.OldHash
+	}
+	if target.IsZero() {
+		return plumbing.ZeroHash, nil, fmt.Errorf("no known good commit to roll back to")
+	}
+	if target == failedHash {
+		return plumbing.ZeroHash, nil, fmt.Errorf("failed commit %s is the last known good commit, not rolling back", failedHash)
+	}
+
+	MarkBadCommit(state, failedHash.String())
+	if err := SaveState(config, state); err != nil {
+		return plumbing.ZeroHash, nil, err
+	}
+
+	logger.Warn("Rolling back to last known good commit", "failed_hash", failedHash, "target_hash", target)
+	repo, err := git.PlainOpen(config.DeploymentDir)
+	if err != nil {
+		return plumbing.ZeroHash, nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+	if err := resetWorktree(repo, config.TargetBranch, target); err != nil {
+		return plumbing.ZeroHash, nil, err
+	}
+	plan, err := Deploy(ctx, cli, config, logger)
+	if err != nil {
+		return target, plan, fmt.Errorf("failed to re-apply commit %s: %w", target, err)
+	}
+	logger.Info("Rollback successful.", "hash", target)
+	return target, plan, nil
 }
 
-func Deploy(ctx context.Context, cli *client.Client, config model.Config, logger *slog.Logger) error {
-	composePath := filepath.Join(config.DeploymentDir, config.ComposeFile)
+// Deploy parses the project's compose file and reconciles it against Docker. When
+// config.DryRun is set nothing is changed and the returned plan only lists intended actions.
+func Deploy(ctx context.Context, cli *client.Client, config model.Config, logger *slog.Logger) (*controller.Plan, error) {
+	return deploy(ctx, cli, config, nil, logger)
+}
 
-	composeConfig, err := controller.ParseComposeFile(composePath)
+// RepairServices reconciles only the given services of the deployed compose file, restoring
+// containers that stopped, disappeared or became unhealthy, and re-creating containers whose
+// image was updated locally. No images are pulled.
+func RepairServices(ctx context.Context, cli *client.Client, config model.Config, services []string, logger *slog.Logger) (*controller.Plan, error) {
+	return deploy(ctx, cli, config, services, logger)
+}
 
+// PullUpdatedImages pulls the images of the deployed compose file whose tag now points to a
+// new digest in the registry and returns the services using them.
+func PullUpdatedImages(ctx context.Context, cli *client.Client, config model.Config, logger *slog.Logger) ([]string, error) {
+	composeConfig, err := controller.ParseComposeFiles(ComposePaths(config))
 	if err != nil {
-		return fmt.Errorf("could not process compose file: %w", err)
+		return nil, fmt.Errorf("could not process compose file: %w", err)
+	}
+	registryAuth, err := loadRegistryAuth(config, logger)
+	if err != nil {
+		return nil, err
+	}
+	return controller.PullUpdatedImages(ctx, cli, composeConfig, config.ImageUpdates == "labeled", registryAuth, logger)
+}
+
+func deploy(ctx context.Context, cli *client.Client, config model.Config, services []string, logger *slog.Logger) (*controller.Plan, error) {
+	composeConfig, err := controller.ParseComposeFiles(ComposePaths(config))
+
+	if err != nil {
+		return nil, fmt.Errorf("could not process compose file: %w", err)
 	}
 
 	logger.Info("Successfully parsed compose file", "services_count", len(composeConfig.Services))
 
 	// The client is now passed in as an argument, no need to create it here
 
-	projectName := filepath.Base(config.DeploymentDir)
+	projectName := config.ProjectName
+	if projectName == "" {
+		projectName = filepath.Base(filepath.Clean(config.DeploymentDir))
+	}
 	logger.Info("Using project name", "project_name", projectName)
 
-	if err := controller.Apply(ctx, cli, projectName, composeConfig, logger); err != nil {
-		return fmt.Errorf("failed to apply compose config: %w", err)
+	registryAuth, err := loadRegistryAuth(config, logger)
+	if err != nil {
+		return nil, err
 	}
-	logger.Info("Deployment applied successfully.")
-	return nil
+	if err := controller.ValidatePullPolicy(config.PullPolicy); err != nil {
+		return nil, fmt.Errorf("invalid pullPolicy: %w", err)
+	}
+	pullTimes, err := loadPullTimes(config)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := controller.ApplyOptions{
+		DryRun:                   config.DryRun,
+		RegistryAuth:             registryAuth,
+		PullPolicy:               config.PullPolicy,
+		PullTimes:                controller.NewPullTimes(pullTimes),
+		HealthTimeout:            time.Duration(config.HealthTimeout) * time.Second,
+		AbortOnDependencyFailure: config.DependencyFailure == "abort",
+		Parallelism:              config.Parallelism,
+		Services:                 services,
+	}
+	start := time.Now()
+	plan, err := controller.Apply(ctx, cli, projectName, composeConfig, opts, logger)
+	if !config.DryRun {
+		if saveErr := savePullTimes(config, opts.PullTimes.Times()); saveErr != nil {
+			logger.Error("ERROR saving image pull times", "error", saveErr)
+		}
+	}
+	// Repairs are not full reconciliations and must not mask a failing deployment.
+	if !config.DryRun && len(services) == 0 {
+		outcome := metrics.Outcome(err)
+		metrics.ReconcileDuration.WithLabelValues(projectName, outcome).Observe(time.Since(start).Seconds())
+		metrics.Reconciliations.WithLabelValues(projectName, outcome).Inc()
+		if err == nil {
+			metrics.LastSuccessfulReconcile.WithLabelValues(projectName).SetToCurrentTime()
+		}
+	}
+	if err != nil {
+		return plan, fmt.Errorf("failed to apply compose config: %w", err)
+	}
+	if config.DryRun {
+		logger.Info("Dry run completed, no changes were made.", "planned_actions", len(plan.Actions))
+	} else {
+		logger.Info("Deployment applied successfully.")
+	}
+	return plan, nil
+}
+
+// ComposePaths returns the project's compose files in merge order. composeFiles takes precedence
+// over composeFile, and relative paths are resolved against the deployment directory.
+func ComposePaths(config model.Config) []string {
+	files := config.ComposeFiles
+	if len(files) == 0 {
+		files = []string{config.ComposeFile}
+	}
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(config.DeploymentDir, f)
+		}
+		paths = append(paths, f)
+	}
+	return paths
+}
+
+// loadRegistryAuth reads the registry credentials from config.yaml and the Docker config file.
+// They are read on every deployment so rotated credentials are picked up without a restart.
+func loadRegistryAuth(config model.Config, logger *slog.Logger) (*controller.RegistryAuth, error) {
+	var credentials []controller.RegistryCredential
+	for _, r := range config.Registries {
+		password := r.Password
+		if r.PasswordFile != "" {
+			data, err := os.ReadFile(r.PasswordFile)
+			if err != nil {
+				return nil, fmt.Errorf("could not read password file for registry %s: %w", r.Server, err)
+			}
+			password = strings.TrimSpace(string(data))
+		}
+		credentials = append(credentials, controller.RegistryCredential{Server: r.Server, Username: r.Username, Password: password})
+	}
+	return controller.LoadRegistryAuth(config.DockerConfigPath, credentials, logger)
 }
@@ -0,0 +1,109 @@
+package operations
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/sithukyaw666/watcher/model"
+)
+
+const (
+	stateFileName = "state.json"
+	pullsFileName = "pulls.json"
+)
+
+// StateDir returns the directory where Watcher keeps its persistent data for a project.
+// It defaults to a directory inside the clone's .git folder, which git ignores and hard
+// resets never touch.
+func StateDir(config model.Config) string {
+	if config.StateDir != "" {
+		return config.StateDir
+	}
+	return filepath.Join(config.DeploymentDir, ".git", "watcher")
+}
+
+// LoadState reads the persisted deployment state. A missing file yields an empty state.
+func LoadState(config model.Config) (*model.DeploymentState, error) {
+	state := new(model.DeploymentState)
+	data, err := os.ReadFile(filepath.Join(StateDir(config), stateFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deployment state: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse deployment state: %w", err)
+	}
+	return state, nil
+}
+
+// SaveState atomically writes the deployment state.
+func SaveState(config model.Config, state *model.DeploymentState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode deployment state: %w", err)
+	}
+	if err := writeStateFile(config, stateFileName, data); err != nil {
+		return fmt.Errorf("failed to write deployment state: %w", err)
+	}
+	return nil
+}
+
+// loadPullTimes reads when the project's images were last pulled. A missing file yields no times.
+func loadPullTimes(config model.Config) (map[string]time.Time, error) {
+	times := make(map[string]time.Time)
+	data, err := os.ReadFile(filepath.Join(StateDir(config), pullsFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return times, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image pull times: %w", err)
+	}
+	if err := json.Unmarshal(data, &times); err != nil {
+		return nil, fmt.Errorf("failed to parse image pull times: %w", err)
+	}
+	return times, nil
+}
+
+// savePullTimes atomically writes when the project's images were last pulled.
+func savePullTimes(config model.Config, times map[string]time.Time) error {
+	data, err := json.MarshalIndent(times, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode image pull times: %w", err)
+	}
+	if err := writeStateFile(config, pullsFileName, data); err != nil {
+		return fmt.Errorf("failed to write image pull times: %w", err)
+	}
+	return nil
+}
+
+// writeStateFile atomically replaces a file in the project's state directory.
+func writeStateFile(config model.Config, name string, data []byte) error {
+	dir := StateDir(config)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	tmpPath := filepath.Join(dir, name+".tmp")
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(dir, name))
+}
+
+// IsBadCommit reports whether a commit previously failed to deploy and was rolled back.
+func IsBadCommit(state *model.DeploymentState, hash string) bool {
+	return slices.Contains(state.BadHashes, hash)
+}
+
+// MarkBadCommit records a commit that failed to deploy so it is not retried.
+func MarkBadCommit(state *model.DeploymentState, hash string) {
+	if !IsBadCommit(state, hash) {
+		state.BadHashes = append(state.BadHashes, hash)
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/sithukyaw666/watcher/model"
+	"github.com/sithukyaw666/watcher/operations"
+)
+
+// defaultHistoryResults is the number of entries returned when no limit is requested.
+const defaultHistoryResults = 50
+
+// NewHistoryHandler serves the deployment history as JSON, newest first. The optional query
+// parameters "project" and "limit" select a single project and the number of entries.
+func NewHistoryHandler(projects []model.Config, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		limit := defaultHistoryResults
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		projectName := r.URL.Query().Get("project")
+
+		entries := []operations.HistoryEntry{}
+		found := false
+		for _, project := range projects {
+			if projectName != "" && project.ProjectName != projectName {
+				continue
+			}
+			found = true
+			projectEntries, err := operations.ReadHistory(project, limit)
+			if err != nil {
+				logger.Error("Could not read deployment history", "project", project.ProjectName, "error", err)
+				http.Error(w, "could not read history", http.StatusInternalServerError)
+				return
+			}
+			entries = append(entries, projectEntries...)
+		}
+		if !found {
+			http.Error(w, "unknown project", http.StatusNotFound)
+			return
+		}
+
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].StartedAt.After(entries[j].StartedAt)
+		})
+		if limit > 0 && len(entries) > limit {
+			entries = entries[:limit]
+		}
+		writeJSON(w, http.StatusOK, entries)
+	})
+}
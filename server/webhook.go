@@ -0,0 +1,198 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// maxPayloadSize bounds the size of a webhook request body.
+const maxPayloadSize = 5 << 20
+
+// WebhookTarget is a project that can be triggered by a push webhook.
+type WebhookTarget struct {
+	Project string
+	RepoURL string
+	Branch  string
+	// Trigger requests a reconciliation cycle. It must not block.
+	Trigger func()
+}
+
+type pushPayload struct {
+	Ref        string          `json:"ref"`
+	Repository *repositoryInfo `json:"repository"`
+	Project    *repositoryInfo `json:"project"`
+}
+
+// repositoryInfo collects the repository URL fields used by GitHub, GitLab and Gitea payloads.
+type repositoryInfo struct {
+	CloneURL   string `json:"clone_url"`
+	SSHURL     string `json:"ssh_url"`
+	GitHTTPURL string `json:"git_http_url"`
+	GitSSHURL  string `json:"git_ssh_url"`
+	HTMLURL    string `json:"html_url"`
+	WebURL     string `json:"web_url"`
+}
+
+func (r *repositoryInfo) urls() []string {
+	if r == nil {
+		return nil
+	}
+	var urls []string
+	for _, u := range []string{r.CloneURL, r.SSHURL, r.GitHTTPURL, r.GitSSHURL, r.HTMLURL, r.WebURL} {
+		if u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// NewWebhookHandler returns a handler for GitHub, GitLab and Gitea push webhooks. Requests must be
+// signed with secret (GitHub and Gitea HMAC-SHA256 signatures, or the GitLab secret token).
+// A push triggers every target whose branch, and repository when the payload names one, match.
+func NewWebhookHandler(secret string, targets []WebhookTarget, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxPayloadSize+1))
+		if err != nil {
+			http.Error(w, "could not read body", http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxPayloadSize {
+			http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if !validSignature(r.Header, body, secret) {
+			logger.Warn("Rejected webhook with an invalid or missing signature", "remote_addr", r.RemoteAddr)
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		event := eventType(r.Header)
+		if event != "" && event != "push" && event != "Push Hook" {
+			logger.Info("Ignoring webhook event", "event", event)
+			writeJSON(w, http.StatusOK, map[string]any{"triggered": []string{}})
+			return
+		}
+
+		var payload pushPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			http.Error(w, "invalid JSON payload", http.StatusBadRequest)
+			return
+		}
+		if !strings.HasPrefix(payload.Ref, "refs/heads/") {
+			logger.Info("Ignoring push that is not to a branch", "ref", payload.Ref)
+			writeJSON(w, http.StatusOK, map[string]any{"triggered": []string{}})
+			return
+		}
+		branch := strings.TrimPrefix(payload.Ref, "refs/heads/")
+		repoURLs := append(payload.Repository.urls(), payload.Project.urls()...)
+
+		triggered := []string{}
+		for _, target := range targets {
+			if target.Branch != branch || !repoMatches(target.RepoURL, repoURLs) {
+				continue
+			}
+			target.Trigger()
+			triggered = append(triggered, target.Project)
+		}
+		logger.Info("Push webhook received", "branch", branch, "triggered", triggered)
+
+		status := http.StatusOK
+		if len(triggered) > 0 {
+			status = http.StatusAccepted
+		}
+		writeJSON(w, status, map[string]any{"triggered": triggered})
+	})
+}
+
+// validSignature checks the signature headers sent by GitHub, Gitea and GitLab.
+func validSignature(header http.Header, body []byte, secret string) bool {
+	if sig := header.Get("X-Hub-Signature-256"); sig != "" {
+		return validHMAC(strings.TrimPrefix(sig, "sha256="), body, secret)
+	}
+	if sig := header.Get("X-Gitea-Signature"); sig != "" {
+		return validHMAC(sig, body, secret)
+	}
+	if sig := header.Get("X-Gogs-Signature"); sig != "" {
+		return validHMAC(sig, body, secret)
+	}
+	if token := header.Get("X-Gitlab-Token"); token != "" {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+	}
+	return false
+}
+
+func validHMAC(signature string, body []byte, secret string) bool {
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+func eventType(header http.Header) string {
+	for _, name := range []string{"X-GitHub-Event", "X-Gitea-Event", "X-Gogs-Event", "X-Gitlab-Event"} {
+		if v := header.Get(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// repoMatches reports whether repoURL is one of the payload's repository URLs. Payloads that
+// do not name a repository match every target.
+func repoMatches(repoURL string, payloadURLs []string) bool {
+	if len(payloadURLs) == 0 {
+		return true
+	}
+	want := normalizeRepoURL(repoURL)
+	for _, u := range payloadURLs {
+		if normalizeRepoURL(u) == want {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeRepoURL reduces SSH, scp-like and HTTPS URLs of the same repository to "host/path".
+func normalizeRepoURL(u string) string {
+	u = strings.ToLower(strings.TrimSpace(u))
+	if i := strings.Index(u, "://"); i >= 0 {
+		u = u[i+3:]
+	} else if colon := strings.Index(u, ":"); colon >= 0 {
+		// scp-like syntax: git@host:path
+		u = u[:colon] + "/" + u[colon+1:]
+	}
+	if at := strings.Index(u, "@"); at >= 0 && at < strings.Index(u+"/", "/") {
+		u = u[at+1:]
+	}
+	u = strings.TrimSuffix(strings.TrimSuffix(u, "/"), ".git")
+	// Drop an explicit port from the host.
+	if slash := strings.Index(u, "/"); slash >= 0 {
+		if colon := strings.Index(u[:slash], ":"); colon >= 0 {
+			u = u[:colon] + u[slash:]
+		}
+	}
+	return u
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
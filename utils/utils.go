@@ -2,6 +2,9 @@ package utils
 
 import (
 	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/sithukyaw666/watcher/model"
 	"github.com/spf13/viper"
@@ -14,6 +17,7 @@ func LoadConfig() (model.Config, error) {
 	viper.SetConfigType("yaml") // or "json" or other formats you prefer
 	viper.AddConfigPath(".")    // look for the config in the current directory
 	viper.AutomaticEnv()
+	viper.SetDefault("parallelism", 4)
 
 	// Read config file
 	if err := viper.ReadInConfig(); err != nil {
@@ -29,41 +33,201 @@ func LoadConfig() (model.Config, error) {
 
 }
 
+// ResolveProjects returns the configuration of every project to manage. Without a projects list
+// the top-level configuration is the single project; otherwise each project inherits the
+// top-level values it does not set itself.
+func ResolveProjects(config model.Config) ([]model.Config, error) {
+	defaults := config
+	defaults.Projects = nil
+
+	projects := config.Projects
+	if len(projects) == 0 {
+		projects = []model.Config{defaults}
+	}
+
+	resolved := make([]model.Config, 0, len(projects))
+	names := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for i, p := range projects {
+		p.Projects = nil
+		if p.ComposeFile == "" && len(p.ComposeFiles) == 0 {
+			p.ComposeFile = defaults.ComposeFile
+			p.ComposeFiles = defaults.ComposeFiles
+		}
+		if p.TargetBranch == "" {
+			p.TargetBranch = defaults.TargetBranch
+		}
+		if p.SSHKeyPath == "" {
+			p.SSHKeyPath = defaults.SSHKeyPath
+		}
+		if p.GitUsername == "" {
+			p.GitUsername = defaults.GitUsername
+		}
+		if p.GitTokenFile == "" {
+			p.GitTokenFile = defaults.GitTokenFile
+		}
+		if p.GitTokenEnv == "" {
+			p.GitTokenEnv = defaults.GitTokenEnv
+		}
+		if p.HistoryLimit == 0 {
+			p.HistoryLimit = defaults.HistoryLimit
+		}
+		if p.CheckInterval == 0 {
+			p.CheckInterval = defaults.CheckInterval
+		}
+		if p.HealthTimeout == 0 {
+			p.HealthTimeout = defaults.HealthTimeout
+		}
+		if p.DependencyFailure == "" {
+			p.DependencyFailure = defaults.DependencyFailure
+		}
+		if p.Parallelism == 0 {
+			p.Parallelism = defaults.Parallelism
+		}
+		if p.ImageCheckInterval == 0 {
+			p.ImageCheckInterval = defaults.ImageCheckInterval
+		}
+		if p.ImageUpdates == "" {
+			p.ImageUpdates = defaults.ImageUpdates
+		}
+		if p.PullPolicy == "" {
+			p.PullPolicy = defaults.PullPolicy
+		}
+		if p.ImageRetention == 0 {
+			p.ImageRetention = defaults.ImageRetention
+		}
+		p.DockerAPIVersion = defaults.DockerAPIVersion
+		if p.DockerConfigPath == "" {
+			p.DockerConfigPath = defaults.DockerConfigPath
+		}
+		if len(p.Registries) == 0 {
+			p.Registries = defaults.Registries
+		}
+		if p.SelfHeal == nil {
+			p.SelfHeal = defaults.SelfHeal
+		}
+		p.DryRun = p.DryRun || defaults.DryRun
+
+		if p.RepoURL == "" || p.DeploymentDir == "" {
+			return nil, fmt.Errorf("project %d: repoURL and deploymentDir are required", i+1)
+		}
+		if p.ProjectName == "" {
+			p.ProjectName = filepath.Base(filepath.Clean(p.DeploymentDir))
+		}
+		if p.CheckInterval <= 0 {
+			return nil, fmt.Errorf("project '%s': checkInterval must be greater than zero", p.ProjectName)
+		}
+		if p.ImageRetention < 0 {
+			return nil, fmt.Errorf("project '%s': imageRetention must not be negative", p.ProjectName)
+		}
+		if p.ImageCheckInterval < 0 {
+			return nil, fmt.Errorf("project '%s': imageCheckInterval must not be negative", p.ProjectName)
+		}
+		if p.ImageUpdates != "" && p.ImageUpdates != "all" && p.ImageUpdates != "labeled" {
+			return nil, fmt.Errorf("project '%s': imageUpdates must be 'all' or 'labeled', got '%s'", p.ProjectName, p.ImageUpdates)
+		}
+		if p.Parallelism < 1 {
+			return nil, fmt.Errorf("project '%s': parallelism must be at least 1", p.ProjectName)
+		}
+		if p.HealthTimeout < 0 {
+			return nil, fmt.Errorf("project '%s': healthTimeout must not be negative", p.ProjectName)
+		}
+		if p.DependencyFailure != "" && p.DependencyFailure != "continue" && p.DependencyFailure != "abort" {
+			return nil, fmt.Errorf("project '%s': dependencyFailure must be 'continue' or 'abort', got '%s'", p.ProjectName, p.DependencyFailure)
+		}
+		if names[p.ProjectName] {
+			return nil, fmt.Errorf("project name '%s' is used more than once", p.ProjectName)
+		}
+		dir := filepath.Clean(p.DeploymentDir)
+		if dirs[dir] {
+			return nil, fmt.Errorf("project '%s': deploymentDir '%s' is used by another project", p.ProjectName, p.DeploymentDir)
+		}
+		names[p.ProjectName] = true
+		dirs[dir] = true
+		resolved = append(resolved, p)
+	}
+	return resolved, nil
+}
+
+// ResolveDependencyOrder returns the services of depMap in an order in which every service
+// comes after its dependencies. The order is stable: services are sorted by dependency level,
+// then by name. It is the flattened result of ResolveDependencyLevels.
 func ResolveDependencyOrder(depMap map[string][]string) ([]string, error) {
-	var ordered []string
-	visiting := make(map[string]bool)
-	visited := make(map[string]bool)
-	var visit func(nodeName string) error
+	levels, err := ResolveDependencyLevels(depMap)
+	if err != nil {
+		return nil, err
+	}
+	ordered := make([]string, 0, len(depMap))
+	for _, level := range levels {
+		ordered = append(ordered, level...)
+	}
+	return ordered, nil
+}
 
-	visit = func(nodeName string) error {
-		if visiting[nodeName] {
-			return fmt.Errorf("circular dependency detected: %s", nodeName)
+// ResolveDependencyLevels groups the services of depMap into levels: every service only depends
+// on services in earlier levels, so the services of one level can be handled concurrently.
+// Services within a level are sorted by name. All undefined dependencies are reported together,
+// and a circular dependency is reported with its full path, e.g. "a -> b -> c -> a".
+func ResolveDependencyLevels(depMap map[string][]string) ([][]string, error) {
+	names := make([]string, 0, len(depMap))
+	for name := range depMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var undefined []string
+	for _, name := range names {
+		for _, dep := range depMap[name] {
+			if _, ok := depMap[dep]; !ok {
+				undefined = append(undefined, fmt.Sprintf("'%s' (required by '%s')", dep, name))
+			}
 		}
-		if visited[nodeName] {
-			return nil
+	}
+	if len(undefined) > 0 {
+		return nil, fmt.Errorf("services are dependencies but are not defined: %s", strings.Join(undefined, ", "))
+	}
+
+	level := make(map[string]int, len(depMap))
+	var path []string
+	onPath := make(map[string]int)
+	var visit func(name string) error
+	visit = func(name string) error {
+		if start, ok := onPath[name]; ok {
+			cycle := append(append([]string{}, path[start:]...), name)
+			return fmt.Errorf("circular dependency detected: %s", strings.Join(cycle, " -> "))
 		}
-		if _, ok := depMap[nodeName]; !ok {
-			return fmt.Errorf("service '%s' is a dependency but is not defined", nodeName)
+		if _, done := level[name]; done {
+			return nil
 		}
-		visiting[nodeName] = true
+		onPath[name] = len(path)
+		path = append(path, name)
 
-		dependencies := depMap[nodeName]
-		for _, dep := range dependencies {
+		deps := append([]string{}, depMap[name]...)
+		sort.Strings(deps)
+		l := 0
+		for _, dep := range deps {
 			if err := visit(dep); err != nil {
 				return err
 			}
+			l = max(l, level[dep]+1)
 		}
-		visiting[nodeName] = false
-		visited[nodeName] = true
-		ordered = append(ordered, nodeName)
+
+		path = path[:len(path)-1]
+		delete(onPath, name)
+		level[name] = l
 		return nil
 	}
-	for nodeName := range depMap {
-		if !visited[nodeName] {
-			if err := visit(nodeName); err != nil {
-				return nil, err
-			}
+	var levels [][]string
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
 		}
 	}
-	return ordered, nil
+	for _, name := range names {
+		for len(levels) <= level[name] {
+			levels = append(levels, nil)
+		}
+		levels[level[name]] = append(levels[level[name]], name)
+	}
+	return levels, nil
 }
@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sithukyaw666/watcher/model"
+	"github.com/spf13/viper"
+)
+
+func TestResolveDependencyLevels(t *testing.T) {
+	tests := []struct {
+		name    string
+		depMap  map[string][]string
+		want    [][]string
+		wantErr string
+	}{
+		{
+			name:   "empty",
+			depMap: map[string][]string{},
+			want:   nil,
+		},
+		{
+			name:   "independent services share a level sorted by name",
+			depMap: map[string][]string{"web": nil, "db": nil, "cache": nil},
+			want:   [][]string{{"cache", "db", "web"}},
+		},
+		{
+			name:   "chain",
+			depMap: map[string][]string{"web": {"api"}, "api": {"db"}, "db": nil},
+			want:   [][]string{{"db"}, {"api"}, {"web"}},
+		},
+		{
+			name:   "level follows the deepest dependency",
+			depMap: map[string][]string{"web": {"db", "api"}, "api": {"db"}, "db": nil, "cache": nil},
+			want:   [][]string{{"cache", "db"}, {"api"}, {"web"}},
+		},
+		{
+			name:   "diamond",
+			depMap: map[string][]string{"app": {"left", "right"}, "left": {"base"}, "right": {"base"}, "base": nil},
+			want:   [][]string{{"base"}, {"left", "right"}, {"app"}},
+		},
+		{
+			name:    "self dependency",
+			depMap:  map[string][]string{"a": {"a"}},
+			wantErr: "circular dependency detected: a -> a",
+		},
+		{
+			name:    "cycle reports its full path",
+			depMap:  map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"a"}},
+			wantErr: "circular dependency detected: a -> b -> c -> a",
+		},
+		{
+			name:    "cycle path excludes services leading into it",
+			depMap:  map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"b"}},
+			wantErr: "circular dependency detected: b -> c -> b",
+		},
+		{
+			name:    "all undefined dependencies are reported together",
+			depMap:  map[string][]string{"web": {"db", "cache"}, "worker": {"queue"}},
+			wantErr: "services are dependencies but are not defined: 'db' (required by 'web'), 'cache' (required by 'web'), 'queue' (required by 'worker')",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveDependencyLevels(tt.depMap)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("ResolveDependencyLevels() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveDependencyLevels() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResolveDependencyLevels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveDependencyOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		depMap  map[string][]string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:   "sorted by level then name",
+			depMap: map[string][]string{"web": {"db", "api"}, "api": {"db"}, "db": nil, "cache": nil},
+			want:   []string{"cache", "db", "api", "web"},
+		},
+		{
+			name:   "diamond",
+			depMap: map[string][]string{"app": {"right", "left"}, "left": {"base"}, "right": {"base"}, "base": nil},
+			want:   []string{"base", "left", "right", "app"},
+		},
+		{
+			name:    "cycle",
+			depMap:  map[string][]string{"a": {"b"}, "b": {"a"}},
+			wantErr: "circular dependency detected: a -> b -> a",
+		},
+		{
+			name:    "undefined dependency",
+			depMap:  map[string][]string{"web": {"db"}},
+			wantErr: "services are dependencies but are not defined: 'db' (required by 'web')",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveDependencyOrder(tt.depMap)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("ResolveDependencyOrder() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveDependencyOrder() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResolveDependencyOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveDependencyOrderIsDeterministic(t *testing.T) {
+	depMap := map[string][]string{"e": {"a"}, "d": {"a"}, "c": nil, "b": {"c"}, "a": nil}
+	first, err := ResolveDependencyOrder(depMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 20 {
+		got, err := ResolveDependencyOrder(depMap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, first) {
+			t.Fatalf("ResolveDependencyOrder() = %v, earlier call returned %v", got, first)
+		}
+	}
+}
+
+func TestResolveProjectsSelfHealAndStateDir(t *testing.T) {
+	const config = `
+selfHeal: true
+stateDir: /var/lib/watcher
+checkInterval: 30
+projects:
+  - repoURL: https://example.com/a.git
+    deploymentDir: /srv/a
+  - repoURL: https://example.com/b.git
+    deploymentDir: /srv/b
+    selfHeal: false
+  - repoURL: https://example.com/c.git
+    deploymentDir: /srv/c
+    stateDir: /var/lib/watcher/c
+`
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+	var cfg model.Config
+	if err := v.Unmarshal(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Parallelism = 1
+
+	projects, err := ResolveProjects(cfg)
+	if err != nil {
+		t.Fatalf("ResolveProjects() error: %v", err)
+	}
+	tests := []struct {
+		project      string
+		wantSelfHeal bool
+		wantStateDir string
+	}{
+		{project: "a", wantSelfHeal: true, wantStateDir: ""},
+		{project: "b", wantSelfHeal: false, wantStateDir: ""},
+		{project: "c", wantSelfHeal: true, wantStateDir: "/var/lib/watcher/c"},
+	}
+	for i, tt := range tests {
+		p := projects[i]
+		if p.ProjectName != tt.project {
+			t.Fatalf("project %d is %q, want %q", i, p.ProjectName, tt.project)
+		}
+		if p.SelfHeal == nil || *p.SelfHeal != tt.wantSelfHeal {
+			t.Errorf("project %s: selfHeal = %v, want %v", tt.project, p.SelfHeal, tt.wantSelfHeal)
+		}
+		if p.StateDir != tt.wantStateDir {
+			t.Errorf("project %s: stateDir = %q, want %q", tt.project, p.StateDir, tt.wantStateDir)
+		}
+	}
+}